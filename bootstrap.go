@@ -0,0 +1,196 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log"
+    "net"
+    "sort"
+    "sync"
+    "time"
+
+    dht "github.com/libp2p/go-libp2p-kad-dht"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    ma "github.com/multiformats/go-multiaddr"
+    manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+    // Peers whose TCP port doesn't answer within the probe timeout are
+    // treated as slow.
+    bootstrapProbeTimeout = 2 * time.Second
+    bootstrapDialTimeout  = 15 * time.Second
+
+    // Number of fastest bootstrap peers dialed before the node is usable.
+    bootstrapFastPeers = 3
+
+    // Number of connected bootstrap peers after which the node is usable.
+    bootstrapMinPeers = 2
+)
+
+// bootstrap probes the latency of all bootstrap peers, dials the
+// bootstrapFastPeers fastest ones and returns once bootstrapMinPeers of
+// them are connected. The rest are dialed in the background, followed by a
+// routing table refresh.
+func bootstrap(ctx context.Context, kdht *dht.IpfsDHT, peers []peer.AddrInfo) error {
+    h := kdht.Host()
+
+    ranked := rankByLatency(ctx, peers)
+    fast, rest := ranked, []peer.AddrInfo(nil)
+    if len(ranked) > bootstrapFastPeers {
+        fast, rest = ranked[:bootstrapFastPeers], ranked[bootstrapFastPeers:]
+    }
+
+    var (
+        mu        sync.Mutex
+        connected int
+        wg        sync.WaitGroup
+    )
+    ready := make(chan struct{})
+
+    for _, pi := range fast {
+        wg.Add(1)
+        go func(pi peer.AddrInfo) {
+            defer wg.Done()
+            dctx, cancel := context.WithTimeout(ctx, bootstrapDialTimeout)
+            defer cancel()
+            if err := h.Connect(dctx, pi); err != nil {
+                // Give it another chance along with the slow peers
+                log.Printf("bootstrap dial %s failed: %v", pi.ID, err)
+                mu.Lock()
+                rest = append(rest, pi)
+                mu.Unlock()
+                return
+            }
+
+            mu.Lock()
+            defer mu.Unlock()
+            connected++
+            if connected == bootstrapMinPeers {
+                close(ready)
+            }
+        }(pi)
+    }
+
+    dialed := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(dialed)
+    }()
+
+    select {
+    case <-ready:
+    case <-dialed:
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+
+    // Dial the slower peers without holding up the caller
+    go func() {
+        <-dialed
+        mu.Lock()
+        slow := rest
+        mu.Unlock()
+
+        for _, pi := range slow {
+            if h.Network().Connectedness(pi.ID) == network.Connected {
+                continue
+            }
+            dctx, cancel := context.WithTimeout(ctx, bootstrapDialTimeout)
+            if err := h.Connect(dctx, pi); err != nil {
+                log.Printf("bootstrap dial %s failed: %v", pi.ID, err)
+            }
+            cancel()
+        }
+        <-kdht.RefreshRoutingTable()
+    }()
+
+    mu.Lock()
+    defer mu.Unlock()
+    if connected == 0 {
+        // The background pass may still succeed
+        return errors.New("none of the fastest bootstrap peers could be dialed")
+    }
+    return nil
+}
+
+// rankByLatency orders peers by how fast one of their TCP addresses
+// accepts a connection. Peers without a TCP address, or that don't answer
+// within bootstrapProbeTimeout, come last in their original order.
+func rankByLatency(ctx context.Context, peers []peer.AddrInfo) []peer.AddrInfo {
+    rtts := make([]time.Duration, len(peers))
+    var wg sync.WaitGroup
+    for i, pi := range peers {
+        wg.Add(1)
+        go func(i int, pi peer.AddrInfo) {
+            defer wg.Done()
+            rtts[i] = probeLatency(ctx, pi)
+        }(i, pi)
+    }
+    wg.Wait()
+
+    idx := make([]int, len(peers))
+    for i := range idx {
+        idx[i] = i
+    }
+    sort.SliceStable(idx, func(a, b int) bool {
+        ra, rb := rtts[idx[a]], rtts[idx[b]]
+        if ra == 0 || rb == 0 {
+            return ra != 0
+        }
+        return ra < rb
+    })
+
+    ranked := make([]peer.AddrInfo, len(peers))
+    for i, j := range idx {
+        ranked[i] = peers[j]
+    }
+    return ranked
+}
+
+// probeLatency returns the fastest TCP connect time to any of the peer's
+// addresses, or zero if none answered. Only the TCP handshake is done, so
+// probing is much cheaper than a libp2p dial.
+func probeLatency(ctx context.Context, pi peer.AddrInfo) time.Duration {
+    pctx, cancel := context.WithTimeout(ctx, bootstrapProbeTimeout)
+    defer cancel()
+
+    var best time.Duration
+    var d net.Dialer
+    for _, addr := range pi.Addrs {
+        if _, err := addr.ValueForProtocol(ma.P_TCP); err != nil {
+            continue
+        }
+        nw, hostport, err := manet.DialArgs(addr)
+        if err != nil {
+            continue
+        }
+        start := time.Now()
+        c, err := d.DialContext(pctx, nw, hostport)
+        if err != nil {
+            continue
+        }
+        rtt := time.Since(start)
+        c.Close()
+        if best == 0 || rtt < best {
+            best = rtt
+        }
+    }
+    return best
+}
+
+// waitForPeers blocks until the routing table holds at least n peers.
+func waitForPeers(ctx context.Context, kdht *dht.IpfsDHT, n int) error {
+    ticker := time.NewTicker(100 * time.Millisecond)
+    defer ticker.Stop()
+
+    for kdht.RoutingTable().Size() < n {
+        select {
+        case <-ticker.C:
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+    }
+    return nil
+}
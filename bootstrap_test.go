@@ -0,0 +1,46 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "testing"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+    ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestRankByLatency(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    go func() {
+        for {
+            c, err := ln.Accept()
+            if err != nil {
+                return
+            }
+            c.Close()
+        }
+    }()
+
+    port := ln.Addr().(*net.TCPAddr).Port
+    listening := ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", port))
+    quicOnly := ma.StringCast("/ip4/127.0.0.1/udp/1/quic-v1")
+
+    peers := []peer.AddrInfo{
+        {ID: "no-addrs"},
+        {ID: "quic-only", Addrs: []ma.Multiaddr{quicOnly}},
+        {ID: "listening", Addrs: []ma.Multiaddr{quicOnly, listening}},
+    }
+    ranked := rankByLatency(context.Background(), peers)
+
+    want := []peer.ID{"listening", "no-addrs", "quic-only"}
+    for i, pi := range ranked {
+        if pi.ID != want[i] {
+            t.Errorf("rank %d: got %s, want %s", i, pi.ID, want[i])
+        }
+    }
+}
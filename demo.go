@@ -0,0 +1,196 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Delay between steps when there is no terminal to press Enter in
+const demoStepDelay = time.Second
+
+// demoScript prints the numbered, annotated steps of the demo and waits
+// for the viewer between them.
+type demoScript struct {
+    step        int
+    in          *bufio.Reader
+    interactive bool
+}
+
+func newDemoScript() *demoScript {
+    fi, err := os.Stdin.Stat()
+    return &demoScript{
+        in:          bufio.NewReader(os.Stdin),
+        interactive: err == nil && fi.Mode()&os.ModeCharDevice != 0,
+    }
+}
+
+func (d *demoScript) say(title string, lines ...string) {
+    d.step++
+    fmt.Printf("\n== Step %d: %s ==\n", d.step, title)
+    for _, l := range lines {
+        fmt.Printf("   %s\n", l)
+    }
+    if d.interactive {
+        fmt.Println("   (press Enter to continue)")
+        if _, err := d.in.ReadString('\n'); err == nil {
+            return
+        }
+        // stdin is a device without input, e.g. /dev/null
+        d.interactive = false
+    }
+    time.Sleep(demoStepDelay)
+}
+
+// demo runs the original walkthrough between two local nodes, explaining
+// each step, and records it as an asciinema cast if castFile is set.
+func demo(castFile string) error {
+    if castFile != "" {
+        rec, err := recordCast(castFile, "go-hello demo")
+        if err != nil {
+            return err
+        }
+        defer rec.Close()
+    }
+    d := newDemoScript()
+
+    // Local nodes never look publicly reachable, so serve the DHT right away
+    cfg := nodeConfig{
+        serverMode:  true,
+        listenAddrs: []string{"/ip4/127.0.0.1/tcp/0"},
+        refreshMin:  time.Minute,
+        refreshMax:  time.Hour,
+    }
+
+    d.say("Start node A",
+        "A libp2p host gets a fresh identity and listens on loopback.",
+        "A Kademlia DHT runs on top of it under the "+dhtProtocolPrefix+" protocol prefix.")
+    a, err := makeNode(cfg)
+    if err != nil {
+        return fmt.Errorf("failed to start node A: %w", err)
+    }
+    defer a.stop()
+    fmt.Printf("   A is %s\n", a.PeerID())
+
+    d.say("Start node B and bootstrap it from A",
+        "B only knows A's address. Connecting makes each node learn that the",
+        "other speaks the DHT protocol, so both add each other to their routing table.")
+    cfg.bootstrapPeers = []peer.AddrInfo{{ID: a.PeerID(), Addrs: a.Host().Addrs()}}
+    b, err := makeNode(cfg)
+    if err != nil {
+        return fmt.Errorf("failed to start node B: %w", err)
+    }
+    defer b.stop()
+    fmt.Printf("   B is %s\n", b.PeerID())
+
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    if err := waitForPeers(ctx, a.IpfsDHT, 1); err != nil {
+        return fmt.Errorf("A never added B to its routing table: %w", err)
+    }
+    if err := waitForPeers(ctx, b.IpfsDHT, 1); err != nil {
+        return fmt.Errorf("B never added A to its routing table: %w", err)
+    }
+    fmt.Printf("   Routing table sizes: A=%d B=%d\n", a.RoutingTable().Size(), b.RoutingTable().Size())
+
+    d.say("A stores foo=bar",
+        "The key becomes "+dhtKey("foo")+". A wraps the value in a record and sends",
+        "PUT_VALUE to the peers closest to the key, here just B.")
+    if _, err := put(a, "foo", []byte("bar"), 1); err != nil {
+        return fmt.Errorf("put failed: %w", err)
+    }
+
+    d.say("B looks up foo",
+        "B asks the peers closest to the key for the record and validates",
+        "what comes back before handing it out.")
+    val := get(b, "foo")
+    fmt.Printf("Retrieved: %s\n", string(val))
+
+    d.say("Done",
+        "Run hello without a command to do the same against a real network:",
+        "start the first node with -server and join it from the others with -bootstrap.")
+    return nil
+}
+
+// castRecorder copies everything written to stdout and the log into an
+// asciinema v2 cast file while still showing it on the terminal.
+type castRecorder struct {
+    f      *os.File
+    enc    *json.Encoder
+    start  time.Time
+    stdout *os.File
+    r, w   *os.File
+    done   chan struct{}
+}
+
+func recordCast(path, title string) (*castRecorder, error) {
+    f, err := os.Create(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create cast file: %w", err)
+    }
+    r, w, err := os.Pipe()
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+
+    c := &castRecorder{
+        f:      f,
+        enc:    json.NewEncoder(f),
+        start:  time.Now(),
+        stdout: os.Stdout,
+        r:      r,
+        w:      w,
+        done:   make(chan struct{}),
+    }
+    header := map[string]interface{}{
+        "version":         2,
+        "width":           100,
+        "height":          30,
+        "timestamp":       c.start.Unix(),
+        "title":           title,
+        "idle_time_limit": 2,
+    }
+    if err := c.enc.Encode(header); err != nil {
+        f.Close()
+        return nil, fmt.Errorf("failed to write cast header: %w", err)
+    }
+
+    os.Stdout = w
+    log.SetOutput(w)
+    go c.copy()
+    return c, nil
+}
+
+func (c *castRecorder) copy() {
+    defer close(c.done)
+    buf := make([]byte, 4096)
+    for {
+        n, err := c.r.Read(buf)
+        if n > 0 {
+            c.stdout.Write(buf[:n])
+            // A terminal would have translated the newlines
+            out := strings.ReplaceAll(string(buf[:n]), "\n", "\r\n")
+            c.enc.Encode([]interface{}{time.Since(c.start).Seconds(), "o", out})
+        }
+        if err != nil {
+            return
+        }
+    }
+}
+
+func (c *castRecorder) Close() error {
+    os.Stdout = c.stdout
+    log.SetOutput(os.Stderr)
+    c.w.Close()
+    <-c.done
+    c.r.Close()
+    return c.f.Close()
+}
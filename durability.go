@@ -0,0 +1,90 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "slices"
+    "strings"
+    "time"
+
+    recpb "github.com/libp2p/go-libp2p-record/pb"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+    replicaWaitTimeout   = time.Minute
+    replicaPutTimeout    = 10 * time.Second
+    replicaRetryInterval = 2 * time.Second
+)
+
+// putReport tells how durable a Put turned out to be. The DHT protocol
+// has no storage receipts, so an acknowledgement is a peer echoing the
+// record back in its PUT_VALUE response.
+type putReport struct {
+    key    string
+    seq    uint64
+    acked  []peer.ID
+    failed map[peer.ID]error
+    hinted []peer.ID
+}
+
+func (t *putTracker) report(key string) *putReport {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    r := &putReport{key: key, acked: slices.Clone(t.acked), failed: make(map[peer.ID]error)}
+    for p, err := range t.failed {
+        r.failed[p] = err
+    }
+    return r
+}
+
+func (r *putReport) String() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "Durability of %s: %d replicas acknowledged, %d failed, %d hinted\n",
+        r.key, len(r.acked), len(r.failed), len(r.hinted))
+    for _, p := range r.acked {
+        fmt.Fprintf(&b, "  acked   %s\n", p)
+    }
+    for p, err := range r.failed {
+        fmt.Fprintf(&b, "  failed  %s: %v\n", p, err)
+    }
+    for _, p := range r.hinted {
+        fmt.Fprintf(&b, "  hinted  %s\n", p)
+    }
+    return b.String()
+}
+
+// awaitReplicas sends rec to targets that haven't acknowledged it yet until
+// at least min peers acknowledged it or ctx is done.
+func awaitReplicas(ctx context.Context, n *node, rec *recpb.Record, r *putReport, targets []peer.ID, min int) error {
+    ticker := time.NewTicker(replicaRetryInterval)
+    defer ticker.Stop()
+
+    for len(r.acked) < min {
+        for _, p := range targets {
+            if slices.Contains(r.acked, p) {
+                continue
+            }
+            pctx, cancel := context.WithTimeout(ctx, replicaPutTimeout)
+            err := n.messenger.PutValue(pctx, p, rec)
+            cancel()
+            if err != nil {
+                r.failed[p] = err
+                continue
+            }
+            delete(r.failed, p)
+            r.acked = append(r.acked, p)
+        }
+        if len(r.acked) >= min {
+            break
+        }
+
+        select {
+        case <-ticker.C:
+        case <-ctx.Done():
+            return fmt.Errorf("only %d of %d replicas acknowledged %s", len(r.acked), min, r.key)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "slices"
+    "testing"
+
+    "github.com/libp2p/go-libp2p-kad-dht/amino"
+    pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+    kb "github.com/libp2p/go-libp2p-kbucket"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// fakeHost answers connectedness from a set of peers and nothing else.
+type fakeHost struct {
+    host.Host
+    net fakeNetwork
+}
+
+func (h fakeHost) Network() network.Network { return h.net }
+
+type fakeNetwork struct {
+    network.Network
+    connected map[peer.ID]bool
+}
+
+func (n fakeNetwork) Connectedness(p peer.ID) network.Connectedness {
+    if n.connected[p] {
+        return network.Connected
+    }
+    return network.NotConnected
+}
+
+func putMessage(value string) *pb.Message {
+    return &pb.Message{Type: pb.Message_PUT_VALUE, Record: hintRecord("foo", 1, value)}
+}
+
+func TestObserve(t *testing.T) {
+    p := fleetPeerID(t, "durability", 0)
+    tests := []struct {
+        name       string
+        req, resp  *pb.Message
+        err        error
+        wantAcked  bool
+        wantFailed bool
+    }{
+        {"echoed", putMessage("bar"), putMessage("bar"), nil, true, false},
+        {"error", putMessage("bar"), nil, errors.New("stream reset"), false, true},
+        {"other value", putMessage("bar"), putMessage("baz"), nil, false, true},
+        {"no record", putMessage("bar"), &pb.Message{Type: pb.Message_PUT_VALUE}, nil, false, true},
+        {"not a put", &pb.Message{Type: pb.Message_GET_VALUE}, &pb.Message{}, nil, false, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ctx, tracker := withPutTracker(context.Background())
+            observe(ctx, p, tt.req, tt.resp, tt.err)
+
+            r := tracker.report("foo")
+            if got := slices.Contains(r.acked, p); got != tt.wantAcked {
+                t.Errorf("acked = %v, want %v", got, tt.wantAcked)
+            }
+            if _, got := r.failed[p]; got != tt.wantFailed {
+                t.Errorf("failed = %v, want %v", got, tt.wantFailed)
+            }
+        })
+    }
+
+    // Puts outside a tracked context are not observed
+    observe(context.Background(), p, putMessage("bar"), putMessage("bar"), nil)
+}
+
+func TestMissed(t *testing.T) {
+    key := dhtKey("foo")
+    var peers []peer.ID
+    for i := 0; i < amino.DefaultBucketSize+5; i++ {
+        peers = append(peers, fleetPeerID(t, "durability", i))
+    }
+    // Closest to key first
+    peers = kb.SortClosestPeers(peers, kb.ConvertKey(key))
+    closest := peers[:amino.DefaultBucketSize]
+    farthest := peers[len(peers)-1]
+
+    tests := []struct {
+        name       string
+        designated []peer.ID
+        acked      []peer.ID
+        failed     []peer.ID
+        connected  []peer.ID
+        want       []peer.ID
+    }{
+        {
+            name:       "only the closest are targets",
+            designated: peers,
+            want:       closest,
+        },
+        {
+            name:       "acked",
+            designated: closest,
+            acked:      closest[:3],
+            want:       closest[3:],
+        },
+        {
+            name:       "connected peers rejected the record",
+            designated: closest,
+            failed:     closest[:2],
+            connected:  closest[1:2],
+            want:       append([]peer.ID{closest[0]}, closest[2:]...),
+        },
+        {
+            name:   "peers the put talked to",
+            acked:  closest[:1],
+            failed: []peer.ID{closest[1], farthest},
+            want:   []peer.ID{closest[1], farthest},
+        },
+        {
+            name: "no targets",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, tracker := withPutTracker(context.Background())
+            tracker.acked = tt.acked
+            for _, p := range tt.failed {
+                tracker.failed[p] = errors.New("unreachable")
+            }
+            h := fakeHost{net: fakeNetwork{connected: make(map[peer.ID]bool)}}
+            for _, p := range tt.connected {
+                h.net.connected[p] = true
+            }
+
+            got := tracker.missed(h, key, tt.designated)
+            if !slices.Equal(got, tt.want) {
+                t.Errorf("missed() = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "maps"
+    "os"
+    "slices"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-kad-dht/amino"
+    pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+    kb "github.com/libp2p/go-libp2p-kbucket"
+    recpb "github.com/libp2p/go-libp2p-record/pb"
+    "github.com/libp2p/go-libp2p/core/event"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "google.golang.org/protobuf/proto"
+)
+
+const (
+    maxHints           = 1024
+    hintTTL            = 24 * time.Hour
+    hintRetryInterval  = time.Minute
+    hintDeliverTimeout = 10 * time.Second
+)
+
+type putTrackerKey struct{}
+
+// putTracker collects how the replica targets of a single PutValue
+// answered. It rides along in the context handed to the DHT and is filled
+// in by the messageSender.
+type putTracker struct {
+    mu     sync.Mutex
+    acked  []peer.ID
+    failed map[peer.ID]error
+}
+
+func withPutTracker(ctx context.Context) (context.Context, *putTracker) {
+    t := &putTracker{failed: make(map[peer.ID]error)}
+    return context.WithValue(ctx, putTrackerKey{}, t), t
+}
+
+func observe(ctx context.Context, p peer.ID, req, resp *pb.Message, err error) {
+    t, ok := ctx.Value(putTrackerKey{}).(*putTracker)
+    if !ok || req.GetType() != pb.Message_PUT_VALUE {
+        return
+    }
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    switch {
+    case err != nil:
+        t.failed[p] = err
+    case bytes.Equal(resp.GetRecord().GetValue(), req.GetRecord().GetValue()):
+        t.acked = append(t.acked, p)
+    default:
+        t.failed[p] = errors.New("value not put correctly")
+    }
+}
+
+// missed returns the replica targets of key that did not store the record
+// and are not connected to us. The targets are the closest peers to key
+// among those our routing table knew before the put (designated) and the
+// peers the put itself talked to. A peer that answered but rejected the
+// record is still connected and is left out, it would reject it again.
+func (t *putTracker) missed(h host.Host, key string, designated []peer.ID) []peer.ID {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    seen := make(map[peer.ID]bool)
+    var candidates []peer.ID
+    for _, ps := range [][]peer.ID{designated, t.acked, slices.Collect(maps.Keys(t.failed))} {
+        for _, p := range ps {
+            if !seen[p] {
+                seen[p] = true
+                candidates = append(candidates, p)
+            }
+        }
+    }
+    targets := kb.SortClosestPeers(candidates, kb.ConvertKey(key))
+    if len(targets) > amino.DefaultBucketSize {
+        targets = targets[:amino.DefaultBucketSize]
+    }
+
+    var missed []peer.ID
+    for _, p := range targets {
+        if slices.Contains(t.acked, p) || h.Network().Connectedness(p) == network.Connected {
+            continue
+        }
+        missed = append(missed, p)
+    }
+    return missed
+}
+
+// hint is a record that a replica target missed while it was unreachable.
+type hint struct {
+    Peer    peer.ID
+    Record  []byte
+    Created time.Time
+}
+
+// hintStore keeps hints until their target peer is back, then delivers
+// them. Hints are kept in memory and, when path is set, in a JSON file so
+// they survive restarts.
+type hintStore struct {
+    host      host.Host
+    messenger *pb.ProtocolMessenger
+    path      string
+
+    mu    sync.Mutex
+    hints map[string]hint
+    // Peers whose hints are being delivered
+    delivering map[peer.ID]bool
+}
+
+func newHintStore(h host.Host, messenger *pb.ProtocolMessenger, path string) (*hintStore, error) {
+    hs := &hintStore{
+        host:       h,
+        messenger:  messenger,
+        path:       path,
+        hints:      make(map[string]hint),
+        delivering: make(map[peer.ID]bool),
+    }
+    if path == "" {
+        return hs, nil
+    }
+
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return hs, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read hints: %w", err)
+    }
+    var saved []hint
+    if err := json.Unmarshal(data, &saved); err != nil {
+        return nil, fmt.Errorf("failed to parse hints %s: %w", path, err)
+    }
+    for _, h := range saved {
+        rec := new(recpb.Record)
+        if err := proto.Unmarshal(h.Record, rec); err != nil {
+            continue
+        }
+        hs.hints[hintID(h.Peer, rec)] = h
+    }
+    return hs, nil
+}
+
+func hintID(p peer.ID, rec *recpb.Record) string {
+    return p.String() + string(rec.GetKey())
+}
+
+// add stores a hint for p, replacing an older hint for the same key.
+func (hs *hintStore) add(p peer.ID, rec *recpb.Record) {
+    data, err := proto.Marshal(rec)
+    if err != nil {
+        log.Printf("Failed to encode hint for %s: %v", p, err)
+        return
+    }
+
+    hs.mu.Lock()
+    defer hs.mu.Unlock()
+    // Replacing a hint doesn't take up room
+    id := hintID(p, rec)
+    if _, ok := hs.hints[id]; !ok && len(hs.hints) >= maxHints {
+        log.Printf("Hint store full, dropping hint for %s", p)
+        return
+    }
+    hs.hints[id] = hint{Peer: p, Record: data, Created: time.Now()}
+    log.Printf("Stored hint for unreachable replica %s", p)
+    hs.saveLocked()
+}
+
+// run delivers hints when their peer connects and retries all pending
+// hints periodically until ctx is done.
+func (hs *hintStore) run(ctx context.Context) error {
+    sub, err := hs.host.EventBus().Subscribe(new(event.EvtPeerConnectednessChanged))
+    if err != nil {
+        return fmt.Errorf("failed to subscribe to connectedness events: %w", err)
+    }
+
+    go func() {
+        defer sub.Close()
+        ticker := time.NewTicker(hintRetryInterval)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case e, ok := <-sub.Out():
+                if !ok {
+                    return
+                }
+                evt := e.(event.EvtPeerConnectednessChanged)
+                if evt.Connectedness == network.Connected {
+                    hs.deliverAsync(ctx, evt.Peer)
+                }
+            case <-ticker.C:
+                for _, p := range hs.pending() {
+                    hs.deliverAsync(ctx, p)
+                }
+            case <-ctx.Done():
+                return
+            }
+        }
+    }()
+    return nil
+}
+
+// pending returns the peers with hints and drops expired ones.
+func (hs *hintStore) pending() []peer.ID {
+    hs.mu.Lock()
+    defer hs.mu.Unlock()
+
+    seen := make(map[peer.ID]bool)
+    expired := false
+    var peers []peer.ID
+    for id, h := range hs.hints {
+        if time.Since(h.Created) > hintTTL {
+            delete(hs.hints, id)
+            expired = true
+            continue
+        }
+        if !seen[h.Peer] {
+            seen[h.Peer] = true
+            peers = append(peers, h.Peer)
+        }
+    }
+    if expired {
+        hs.saveLocked()
+    }
+    return peers
+}
+
+// count returns the number of hints not yet delivered.
+func (hs *hintStore) count() int {
+    hs.mu.Lock()
+    defer hs.mu.Unlock()
+    return len(hs.hints)
+}
+
+// deliverAsync delivers the hints for p in the background, so that a peer
+// that doesn't answer holds up neither the event loop nor other peers.
+func (hs *hintStore) deliverAsync(ctx context.Context, p peer.ID) {
+    hs.mu.Lock()
+    defer hs.mu.Unlock()
+    if hs.delivering[p] || !hs.hasHintsLocked(p) {
+        return
+    }
+    hs.delivering[p] = true
+
+    go func() {
+        hs.deliver(ctx, p)
+        hs.mu.Lock()
+        delete(hs.delivering, p)
+        hs.mu.Unlock()
+    }()
+}
+
+func (hs *hintStore) hasHintsLocked(p peer.ID) bool {
+    for _, h := range hs.hints {
+        if h.Peer == p {
+            return true
+        }
+    }
+    return false
+}
+
+func (hs *hintStore) deliver(ctx context.Context, p peer.ID) {
+    hs.mu.Lock()
+    var due map[string]hint
+    for id, h := range hs.hints {
+        if h.Peer == p {
+            if due == nil {
+                due = make(map[string]hint)
+            }
+            due[id] = h
+        }
+    }
+    hs.mu.Unlock()
+
+    for id, h := range due {
+        rec := new(recpb.Record)
+        if err := proto.Unmarshal(h.Record, rec); err != nil {
+            continue
+        }
+        dctx, cancel := context.WithTimeout(ctx, hintDeliverTimeout)
+        err := hs.messenger.PutValue(dctx, p, rec)
+        cancel()
+        if err != nil {
+            // Still down, try again later
+            return
+        }
+
+        hs.mu.Lock()
+        // Don't drop a newer hint stored while we were delivering
+        if cur, ok := hs.hints[id]; ok && cur.Created.Equal(h.Created) {
+            delete(hs.hints, id)
+        }
+        hs.saveLocked()
+        hs.mu.Unlock()
+        log.Printf("Delivered hinted record %s to %s", rec.GetKey(), p)
+    }
+}
+
+func (hs *hintStore) saveLocked() {
+    if hs.path == "" {
+        return
+    }
+    saved := make([]hint, 0, len(hs.hints))
+    for _, h := range hs.hints {
+        saved = append(saved, h)
+    }
+    data, err := json.Marshal(saved)
+    if err == nil {
+        err = os.WriteFile(hs.path, data, 0o600)
+    }
+    if err != nil {
+        log.Printf("Failed to save hints: %v", err)
+    }
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    record "github.com/libp2p/go-libp2p-record"
+    recpb "github.com/libp2p/go-libp2p-record/pb"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "google.golang.org/protobuf/proto"
+)
+
+func hintRecord(key string, seq uint64, value string) *recpb.Record {
+    return record.MakePutRecord(dhtKey(key), encodeValue(seq, []byte(value)))
+}
+
+// hintedValue returns the value of the hint for p and key, if any.
+func hintedValue(t *testing.T, hs *hintStore, p peer.ID, key string) (string, bool) {
+    t.Helper()
+    hs.mu.Lock()
+    h, ok := hs.hints[hintID(p, hintRecord(key, 0, ""))]
+    hs.mu.Unlock()
+    if !ok {
+        return "", false
+    }
+    rec := new(recpb.Record)
+    if err := proto.Unmarshal(h.Record, rec); err != nil {
+        t.Fatal(err)
+    }
+    _, val, err := decodeValue(rec.GetValue())
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(val), true
+}
+
+func TestHintStorePersists(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "hints.json")
+    a, b := fleetPeerID(t, "hints", 0), fleetPeerID(t, "hints", 1)
+
+    hs, err := newHintStore(nil, nil, path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    hs.add(a, hintRecord("foo", 1, "bar"))
+    hs.add(b, hintRecord("foo", 1, "bar"))
+    hs.add(a, hintRecord("baz", 1, "qux"))
+
+    loaded, err := newHintStore(nil, nil, path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := loaded.count(); got != 3 {
+        t.Fatalf("loaded %d hints, want 3", got)
+    }
+    for _, want := range []struct {
+        p          peer.ID
+        key, value string
+    }{{a, "foo", "bar"}, {b, "foo", "bar"}, {a, "baz", "qux"}} {
+        if got, ok := hintedValue(t, loaded, want.p, want.key); !ok || got != want.value {
+            t.Errorf("hint for %s %s = %q, %v, want %q", want.p, want.key, got, ok, want.value)
+        }
+    }
+}
+
+func TestHintStoreLoad(t *testing.T) {
+    tests := []struct {
+        name    string
+        data    string // file content, none if empty
+        want    int
+        wantErr bool
+    }{
+        {"no file", "", 0, false},
+        {"no hints", "[]", 0, false},
+        {"garbage", "{", 0, true},
+        {"undecodable record", `[{"Peer":"` + fleetPeerID(t, "hints", 0).String() + `","Record":"AQ==","Created":"2026-01-01T00:00:00Z"}]`, 0, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), "hints.json")
+            if tt.data != "" {
+                if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            hs, err := newHintStore(nil, nil, path)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("newHintStore() error = %v, wantErr %v", err, tt.wantErr)
+            }
+            if err == nil && hs.count() != tt.want {
+                t.Errorf("loaded %d hints, want %d", hs.count(), tt.want)
+            }
+        })
+    }
+}
+
+func TestHintStoreReplaces(t *testing.T) {
+    p := fleetPeerID(t, "hints", 0)
+    hs, err := newHintStore(nil, nil, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    hs.add(p, hintRecord("foo", 1, "old"))
+    hs.add(p, hintRecord("foo", 2, "new"))
+    if got := hs.count(); got != 1 {
+        t.Fatalf("%d hints after replacing one, want 1", got)
+    }
+    if got, _ := hintedValue(t, hs, p, "foo"); got != "new" {
+        t.Errorf("hinted value %q, want new", got)
+    }
+
+    // A full store still takes newer records for keys it already has
+    for i := 1; i < maxHints; i++ {
+        hs.add(p, hintRecord(fmt.Sprint("key", i), 1, "v"))
+    }
+    hs.add(p, hintRecord("foo", 3, "newest"))
+    hs.add(p, hintRecord("other", 1, "v"))
+    if got := hs.count(); got != maxHints {
+        t.Errorf("%d hints, want %d", got, maxHints)
+    }
+    if got, _ := hintedValue(t, hs, p, "foo"); got != "newest" {
+        t.Errorf("hinted value %q in a full store, want newest", got)
+    }
+    if _, ok := hintedValue(t, hs, p, "other"); ok {
+        t.Error("new key hinted in a full store")
+    }
+}
+
+func TestHintStoreExpires(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "hints.json")
+    live, expired := fleetPeerID(t, "hints", 0), fleetPeerID(t, "hints", 1)
+
+    hs, err := newHintStore(nil, nil, path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    hs.add(live, hintRecord("foo", 1, "bar"))
+    hs.add(expired, hintRecord("foo", 1, "bar"))
+    hs.mu.Lock()
+    id := hintID(expired, hintRecord("foo", 0, ""))
+    h := hs.hints[id]
+    h.Created = time.Now().Add(-hintTTL - time.Minute)
+    hs.hints[id] = h
+    hs.mu.Unlock()
+
+    if got := hs.pending(); len(got) != 1 || got[0] != live {
+        t.Errorf("pending() = %v, want [%s]", got, live)
+    }
+
+    // The expired hint is gone from the file as well
+    loaded, err := newHintStore(nil, nil, path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := hintedValue(t, loaded, expired, "foo"); ok {
+        t.Error("expired hint still saved")
+    }
+    if got := loaded.count(); got != 1 {
+        t.Errorf("loaded %d hints, want 1", got)
+    }
+}
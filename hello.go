@@ -2,84 +2,463 @@ package main
 
 import (
     "context"
+    "errors"
+    "flag"
     "fmt"
     "log"
+    "maps"
+    "os"
+    "os/signal"
+    "slices"
+    "strings"
+    "syscall"
     "time"
 
     dht "github.com/libp2p/go-libp2p-kad-dht"
+    "github.com/libp2p/go-libp2p-kad-dht/amino"
+    pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+    kb "github.com/libp2p/go-libp2p-kbucket"
+    record "github.com/libp2p/go-libp2p-record"
     libp2p "github.com/libp2p/go-libp2p"
+    "github.com/libp2p/go-libp2p/core/crypto"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+    "github.com/libp2p/go-libp2p/core/routing"
+    ma "github.com/multiformats/go-multiaddr"
 )
 
-func makeNode() (*dht.IpfsDHT, error) {
-    ctx := context.Background()
+// Protocol prefix of our DHT network. The public /ipfs DHT only accepts
+// /pk and /ipns records, so /myapp values live in a network of their own.
+const dhtProtocolPrefix = "/myapp"
 
-    host, err := libp2p.New()
+const kadProtocol = dhtProtocolPrefix + "/kad/1.0.0"
+
+type nodeConfig struct {
+    // How long the node must stay publicly reachable before it serves the DHT
+    promoteAfter time.Duration
+    // File to keep hinted handoff records in, memory only if empty
+    hintsFile string
+    // Peers of our DHT network to join through
+    bootstrapPeers []peer.AddrInfo
+    // Bounds for the churn-driven routing table refresh interval
+    refreshMin, refreshMax time.Duration
+    // Inactivity after which the node goes idle, never if zero
+    idleAfter time.Duration
+    // Multiaddrs to listen on, libp2p's defaults if empty
+    listenAddrs []string
+    // Directory to keep routing table snapshots in, none if empty
+    snapshotDir string
+    // Serve the DHT from the start instead of waiting for promotion
+    serverMode bool
+    // Answer perf requests from other nodes
+    perfResponder bool
+    // Key to use instead of a fresh one
+    identity crypto.PrivKey
+    // Fetch keys that usually follow a read before they are asked for
+    prefetch bool
+    // Refresh the routing table around each key before Put/Get
+    refreshKeys bool
+}
+
+type node struct {
+    *dht.IpfsDHT
+    messenger *pb.ProtocolMessenger
+    hints     *hintStore
+    idle      *idleTracker
+    stats     *nodeStats
+    prefetch  *prefetcher
+    refresh   bool
+    // Stops the node's background loops
+    cancel context.CancelFunc
+}
+
+func makeNode(cfg nodeConfig) (n *node, err error) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer func() {
+        if err != nil {
+            cancel()
+        }
+    }()
+
+    // The default transports include WebRTC, which advertises certhash
+    // addresses that browsers can dial directly. Our peers are the only
+    // ones that can tell us whether we are reachable, so every node answers
+    // AutoNAT dial-back requests.
+    opts := []libp2p.Option{libp2p.EnableNATService()}
+    if len(cfg.listenAddrs) > 0 {
+        opts = append(opts, libp2p.ListenAddrStrings(cfg.listenAddrs...))
+    }
+    if cfg.identity != nil {
+        opts = append(opts, libp2p.Identity(cfg.identity))
+    }
+
+    h, err := libp2p.New(opts...)
     if err != nil {
         return nil, fmt.Errorf("failed to create libp2p host: %w", err)
     }
+    defer func() {
+        if err != nil {
+            h.Close()
+        }
+    }()
+
+    if cfg.perfResponder {
+        servePerf(h)
+    }
+
+    // Start as a DHT client, switch to server once reachability is stable
+    stable, err := newStableHost(ctx, h, cfg.promoteAfter)
+    if err != nil {
+        return nil, err
+    }
+
+    // Inbound DHT requests and our own Put/Get keep the node awake
+    idle := newIdleTracker(ctx, cfg.idleAfter, cfg.bootstrapPeers)
+    stats := newNodeStats()
+
+    mode := dht.ModeAuto
+    if cfg.serverMode {
+        mode = dht.ModeServer
+    }
 
     // Create a new DHT instance
-    kdht, err := dht.New(ctx, host)
+    var sender *messageSender
+    kdht, err := dht.New(ctx, stable,
+        dht.Mode(mode),
+        dht.ProtocolPrefix(dhtProtocolPrefix),
+        dht.NamespacedValidator("myapp", appValidator{}),
+        // bootstrap dials cfg.bootstrapPeers itself, fastest first
+        // refreshLoop takes care of periodic refreshes
+        dht.DisableAutoRefresh(),
+        dht.WithCustomMessageSender(func(dh host.Host, protos []protocol.ID) pb.MessageSenderWithDisconnect {
+            sender = newMessageSender(dh, protos)
+            return sender
+        }),
+        dht.OnRequestHook(func(context.Context, network.Stream, *pb.Message) {
+            idle.touch(false)
+            stats.served.Add(1)
+        }),
+    )
     if err != nil {
         return nil, fmt.Errorf("failed to create DHT: %w", err)
     }
 
+    messenger, err := pb.NewProtocolMessenger(sender)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create DHT messenger: %w", err)
+    }
+
+    // Keep records for replicas that were down during a Put
+    hints, err := newHintStore(h, messenger, cfg.hintsFile)
+    if err != nil {
+        return nil, err
+    }
+    if err := hints.run(ctx); err != nil {
+        return nil, err
+    }
+
     // Bootstrap the DHT
     if err := kdht.Bootstrap(ctx); err != nil {
         return nil, fmt.Errorf("failed to bootstrap DHT: %w", err)
     }
+    go refreshLoop(ctx, kdht, idle, cfg.refreshMin, cfg.refreshMax)
+    go watchNetwork(ctx, kdht, cfg.bootstrapPeers)
+    if cfg.snapshotDir != "" {
+        go snapshotLoop(ctx, kdht, cfg.snapshotDir)
+    }
+    idle.start(kdht)
+
+    // Dial the fastest bootstrap peers first, the rest finish in the background
+    if len(cfg.bootstrapPeers) > 0 {
+        if err := bootstrap(ctx, kdht, cfg.bootstrapPeers); err != nil {
+            log.Printf("Bootstrap: %v", err)
+        }
+    }
 
-    return kdht, nil
+    n = &node{IpfsDHT: kdht, messenger: messenger, hints: hints, idle: idle, stats: stats, refresh: cfg.refreshKeys, cancel: cancel}
+    if cfg.prefetch {
+        n.prefetch = newPrefetcher(ctx, kdht)
+    }
+    return n, nil
 }
 
-func put(kdht *dht.IpfsDHT, key string, value []byte) {
-    ctx := context.Background()
-    // Use a valid DHT key prefix (e.g., "/appname/") for storing values
-    err := kdht.PutValue(ctx, "/myapp/"+key, value)
+// Use a valid DHT key prefix (e.g., "/appname/") for storing values
+func dhtKey(key string) string {
+    return "/myapp/" + key
+}
+
+// put stores value under key. It fails if fewer than minReplicas peers
+// acknowledged the record in time, but still returns the report then.
+func put(n *node, key string, value []byte, minReplicas int) (*putReport, error) {
+    n.idle.touch(true)
+    n.stats.puts.Add(1)
+    defer n.stats.begin()()
+    if n.prefetch != nil {
+        n.prefetch.invalidate(key)
+    }
+    k := dhtKey(key)
+    n.refreshKey(k)
+    designated := n.RoutingTable().NearestPeers(kb.ConvertKey(k), amino.DefaultBucketSize)
+
+    seq := nextSeq()
+    raw := encodeValue(seq, value)
+    ctx, tracker := withPutTracker(context.Background())
+    err := n.PutValue(ctx, k, raw)
     if err != nil {
-        log.Printf("PutValue error: %v", err)
-    } else {
-        fmt.Printf("Successfully stored key: %s\n", key)
+        n.stats.putErrors.Add(1)
+        return nil, err
     }
+    report := tracker.report(k)
+    report.seq = seq
+    missed := tracker.missed(n.Host(), k, designated)
+    rec := record.MakePutRecord(k, raw)
+
+    // Keep trying the missing replicas until enough of them have the record
+    if len(report.acked) < minReplicas {
+        wctx, cancel := context.WithTimeout(context.Background(), replicaWaitTimeout)
+        err = awaitReplicas(wctx, n, rec, report, append(missed, slices.Collect(maps.Keys(report.failed))...), minReplicas)
+        cancel()
+    }
+
+    // Hand off the record for replicas we couldn't reach
+    for _, p := range missed {
+        if !slices.Contains(report.acked, p) {
+            n.hints.add(p, rec)
+            report.hinted = append(report.hinted, p)
+        }
+    }
+
+    fmt.Print(report)
+    if err != nil {
+        n.stats.putErrors.Add(1)
+        return report, err
+    }
+    fmt.Printf("Successfully stored key: %s\n", key)
+    return report, nil
 }
 
-func get(kdht *dht.IpfsDHT, key string) []byte {
-    ctx := context.Background()
-    // Use the same key format for retrieval
-    ch, err := kdht.SearchValue(ctx, "/myapp/"+key)
+// read does the bookkeeping shared by every read of key and resolves it
+// with lookup, which returns routing.ErrNotFound if there is no value.
+func read(n *node, key string, lookup func(ctx context.Context, k string) ([]byte, error)) []byte {
+    n.idle.touch(true)
+    n.stats.gets.Add(1)
+    defer n.stats.begin()()
+    if n.prefetch != nil {
+        n.prefetch.accessed(key)
+    }
+
+    val, err := lookup(context.Background(), dhtKey(key))
+    if errors.Is(err, routing.ErrNotFound) {
+        n.stats.getMisses.Add(1)
+        fmt.Printf("No value found for key=%s\n", key)
+        return nil
+    }
     if err != nil {
-        log.Printf("SearchValue error: %v", err)
+        n.stats.getErrors.Add(1)
+        log.Printf("GetValue error: %v", err)
         return nil
     }
+    return val
+}
 
-    for val := range ch {
-        fmt.Printf("Found value for key=%s: %s\n", key, string(val))
-        return val
-    }
+func get(n *node, key string) []byte {
+    return read(n, key, func(ctx context.Context, k string) ([]byte, error) {
+        if n.prefetch != nil {
+            if val, ok := n.prefetch.cached(key); ok {
+                fmt.Printf("Found prefetched value for key=%s: %s\n", key, string(val))
+                return val, nil
+            }
+        }
+        n.refreshKey(k)
 
-    fmt.Printf("No value found for key=%s\n", key)
-    return nil
+        // Waits for enough peers to pick the newest value, unlike SearchValue
+        // which hands out whatever arrives first
+        raw, err := n.GetValue(ctx, k)
+        if err != nil {
+            return nil, err
+        }
+        _, val, _ := decodeValue(raw)
+        fmt.Printf("Found value for key=%s: %s\n", key, string(val))
+        return val, nil
+    })
 }
 
 func main() {
-    kdht, err := makeNode()
+    var cfg nodeConfig
+    flag.DurationVar(&cfg.promoteAfter, "promote-after", 10*time.Minute, "how long the node must stay publicly reachable before acting as a DHT server")
+    flag.StringVar(&cfg.hintsFile, "hints", "", "file to persist hinted handoff records in")
+    flag.DurationVar(&cfg.refreshMin, "refresh-min", time.Minute, "shortest routing table refresh interval, used under high churn")
+    flag.DurationVar(&cfg.refreshMax, "refresh-max", time.Hour, "longest routing table refresh interval, used when the network is stable")
+    flag.StringVar(&cfg.snapshotDir, "rt-snapshots", "", "directory to write a routing table snapshot to every minute")
+    flag.DurationVar(&cfg.idleAfter, "idle-after", 0, "close most connections and pause refreshes after this long without activity")
+    flag.BoolVar(&cfg.prefetch, "prefetch", false, "learn which keys are read after which and fetch them ahead of time")
+    flag.BoolVar(&cfg.serverMode, "server", false, "serve the DHT from the start, for the first node of the network and other well-known peers")
+    flag.BoolVar(&cfg.perfResponder, "perf", false, "answer echo, payload and bandwidth tests from other nodes")
+    minReplicas := flag.Int("min-replicas", 0, "block Put until this many peers acknowledged the record")
+    flag.BoolVar(&cfg.refreshKeys, "refresh", false, "refresh the routing table around each key before Put/Get")
+    bootstrapAddrs := flag.String("bootstrap", "", "comma-separated multiaddrs of peers to bootstrap from")
+    explainGet := flag.Bool("explain", false, "print where the retrieved value came from and how many peers agree on it")
+    listenAddrs := flag.String("listen", "", "comma-separated multiaddrs to listen on, e.g. /ip4/0.0.0.0/udp/4001/webrtc-direct")
+    castFile := flag.String("cast", "", "record the demo command as an asciinema cast in this file")
+    reportFile := flag.String("report", "", "also write the shutdown report to this file as JSON")
+    identitySeed := flag.String("test-identity-seed", "", "for tests only: derive the node key from this seed and -test-identity-index")
+    identityIndex := flag.Int("test-identity-index", 0, "index of this node in the test fleet, see -test-identity-seed")
+    redisAddr := flag.String("redis", "", "serve GET/SET over the Redis protocol on this address instead of running the walkthrough")
+    flag.Parse()
+
+    // Commands that don't need a running node run right away, the others
+    // are checked before the node starts
+    perfDuration := 30 * time.Second
+    var perfTarget string
+    switch cmd := flag.Arg(0); cmd {
+    case "rt":
+        if flag.NArg() != 4 || flag.Arg(1) != "diff" || cfg.snapshotDir == "" {
+            log.Fatalf("Usage: hello -rt-snapshots <dir> rt diff <t1> <t2>")
+        }
+        if err := rtDiff(cfg.snapshotDir, flag.Arg(2), flag.Arg(3)); err != nil {
+            log.Fatalf("Diff failed: %v", err)
+        }
+        return
+    case "demo":
+        if err := demo(*castFile); err != nil {
+            log.Fatalf("Demo failed: %v", err)
+        }
+        return
+    case "":
+    case "probe":
+        if flag.NArg() != 2 {
+            log.Fatalf("Usage: hello [flags] probe <peer multiaddr or ID>")
+        }
+    case "perf":
+        fs := flag.NewFlagSet("perf", flag.ExitOnError)
+        fs.DurationVar(&perfDuration, "duration", perfDuration, "how long to measure for")
+        fs.Parse(flag.Args()[1:])
+        // Flags may also follow the peer
+        perfTarget = fs.Arg(0)
+        if fs.NArg() > 0 {
+            fs.Parse(fs.Args()[1:])
+        }
+        if perfTarget == "" || fs.NArg() != 0 {
+            log.Fatalf("Usage: hello [flags] perf <peer multiaddr or ID> [-duration 30s]")
+        }
+    case "key":
+        if flag.NArg() < 2 {
+            log.Fatalf("Usage: hello [flags] key <key>...")
+        }
+    default:
+        log.Fatalf("Unknown command %q", cmd)
+    }
+
+    if cfg.refreshMin > cfg.refreshMax {
+        log.Fatalf("-refresh-min %s is larger than -refresh-max %s", cfg.refreshMin, cfg.refreshMax)
+    }
+
+    for _, addr := range strings.Split(*bootstrapAddrs, ",") {
+        if addr == "" {
+            continue
+        }
+        pi, err := peer.AddrInfoFromString(addr)
+        if err != nil {
+            log.Fatalf("Invalid bootstrap address %q: %v", addr, err)
+        }
+        cfg.bootstrapPeers = append(cfg.bootstrapPeers, *pi)
+    }
+    for _, addr := range strings.Split(*listenAddrs, ",") {
+        if addr != "" {
+            cfg.listenAddrs = append(cfg.listenAddrs, addr)
+        }
+    }
+
+    if *identitySeed != "" {
+        key, err := fleetIdentity(*identitySeed, *identityIndex)
+        if err != nil {
+            log.Fatalf("Invalid test identity: %v", err)
+        }
+        log.Printf("Using test identity %d derived from a seed, do not use outside tests", *identityIndex)
+        cfg.identity = key
+    }
+
+    n, err := makeNode(cfg)
     if err != nil {
         log.Fatalf("Failed to start node: %v", err)
     }
+    for _, addr := range n.Host().Addrs() {
+        fmt.Printf("Listening on %s/p2p/%s\n", addr, n.PeerID())
+    }
+    for _, addr := range n.Host().Addrs() {
+        if _, err := addr.ValueForProtocol(ma.P_WEBRTC_DIRECT); err == nil {
+            fmt.Printf("Browsers can dial %s/p2p/%s\n", addr, n.PeerID())
+        }
+    }
+
+    // Every mode stops on SIGINT/SIGTERM, and every way out goes through
+    // shutdown so that the report is written
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    if *redisAddr != "" {
+        err = serveRedisUntil(ctx, *redisAddr, n, *minReplicas)
+    } else {
+        err = untilSignal(ctx, func() error {
+            switch flag.Arg(0) {
+            case "probe":
+                if err := probe(n, flag.Arg(1)); err != nil {
+                    return fmt.Errorf("probe failed: %w", err)
+                }
+                return nil
+            case "perf":
+                if err := perf(n, perfTarget, perfDuration); err != nil {
+                    return fmt.Errorf("perf failed: %w", err)
+                }
+                return nil
+            case "key":
+                explainKeys(n, flag.Args()[1:])
+                return nil
+            }
+            return walkthrough(n, *minReplicas, *explainGet)
+        })
+    }
+    stop()
+
+    n.shutdown(*reportFile, err)
+    if err != nil {
+        log.Fatal(err)
+    }
+}
 
-    // Let the DHT routing table populate
-    time.Sleep(30 * time.Second)
+// walkthrough stores a value and reads it back.
+func walkthrough(n *node, minReplicas int, explainGet bool) error {
+    // Wait until the routing table has enough peers for a first Put/Get
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    if err := waitForPeers(ctx, n.IpfsDHT, bootstrapMinPeers); err != nil {
+        log.Printf("Routing table not ready: %v", err)
+    }
+    cancel()
 
-    fmt.Printf("Routing table size: %d\n", kdht.RoutingTable().Size())
+    fmt.Printf("Routing table size: %d\n", n.RoutingTable().Size())
+
+    // Reads in this session see its own writes
+    s := newSession(n)
 
     // Store a value
-    put(kdht, "foo", []byte("bar"))
+    if _, err := s.put("foo", []byte("bar"), minReplicas); err != nil {
+        return fmt.Errorf("put failed: %w", err)
+    }
 
     // Small wait to simulate network propagation
     time.Sleep(1 * time.Second)
 
     // Retrieve the value
-    val := get(kdht, "foo")
+    val := s.get("foo")
     fmt.Printf("Retrieved: %s\n", string(val))
-}
 
+    if explainGet {
+        pv, err := explain(n, "foo")
+        if err != nil {
+            log.Printf("Explain error: %v", err)
+        } else {
+            fmt.Print(pv)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "fmt"
+
+    "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// fleetIdentity derives the key of node index in a simulated fleet from a
+// shared seed, so the fleet keeps the same peer IDs across runs. Anyone who
+// knows the seed has the key, so it is only meant for tests.
+func fleetIdentity(seed string, index int) (crypto.PrivKey, error) {
+    sum := sha256.Sum256([]byte(fmt.Sprintf("go-hello fleet identity\x00%s\x00%d", seed, index)))
+    priv, _, err := crypto.GenerateEd25519Key(bytes.NewReader(sum[:]))
+    if err != nil {
+        return nil, fmt.Errorf("failed to derive identity: %w", err)
+    }
+    return priv, nil
+}
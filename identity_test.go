@@ -0,0 +1,53 @@
+package main
+
+import (
+    "testing"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+func fleetPeerID(t *testing.T, seed string, index int) peer.ID {
+    t.Helper()
+    key, err := fleetIdentity(seed, index)
+    if err != nil {
+        t.Fatal(err)
+    }
+    id, err := peer.IDFromPrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return id
+}
+
+func TestFleetIdentity(t *testing.T) {
+    tests := []struct {
+        name       string
+        seedA      string
+        indexA     int
+        seedB      string
+        indexB     int
+        wantSameID bool
+    }{
+        {"same seed and index", "fleet", 3, "fleet", 3, true},
+        {"other index", "fleet", 3, "fleet", 4, false},
+        {"other seed", "fleet", 3, "fleet2", 3, false},
+        // The separator keeps seed and index from running into each other
+        {"seed ending in a digit", "fleet1", 2, "fleet", 12, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            a, b := fleetPeerID(t, tt.seedA, tt.indexA), fleetPeerID(t, tt.seedB, tt.indexB)
+            if (a == b) != tt.wantSameID {
+                t.Errorf("IDs %s and %s, want same = %v", a, b, tt.wantSameID)
+            }
+        })
+    }
+}
+
+// Peer IDs must not change between releases, or golden files break
+func TestFleetIdentityStable(t *testing.T) {
+    const want = "12D3KooWKbamLo1ivmphZScSk2qhkfsYkxov2HmiPFvsbyssjL5r"
+    if got := fleetPeerID(t, "abc", 3); got.String() != want {
+        t.Errorf("fleet identity abc/3 = %s, want %s", got, want)
+    }
+}
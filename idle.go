@@ -0,0 +1,144 @@
+package main
+
+import (
+    "context"
+    "log"
+    "sync"
+    "time"
+
+    dht "github.com/libp2p/go-libp2p-kad-dht"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Routing table peers we stay connected to while idle
+const idleKeepPeers = 4
+
+// idleTracker puts the node to sleep after a period without Put/Get calls
+// or inbound DHT requests. A sleeping node skips routing table refreshes
+// and drops all but a few connections; the next call or request wakes it.
+type idleTracker struct {
+    ctx            context.Context
+    timeout        time.Duration
+    bootstrapPeers []peer.AddrInfo
+
+    mu   sync.Mutex
+    kdht *dht.IpfsDHT
+    last time.Time
+    idle bool
+    // Closed once the current sleep or wake up finished, nil otherwise.
+    // The two never overlap: a wake up waits for the sleep before it, and
+    // the node doesn't go to sleep while it is waking up.
+    sleeping chan struct{}
+    waking   chan struct{}
+}
+
+func newIdleTracker(ctx context.Context, timeout time.Duration, bootstrapPeers []peer.AddrInfo) *idleTracker {
+    return &idleTracker{
+        ctx:            ctx,
+        timeout:        timeout,
+        bootstrapPeers: bootstrapPeers,
+        last:           time.Now(),
+    }
+}
+
+// start begins watching for inactivity. A zero timeout disables idling.
+func (it *idleTracker) start(kdht *dht.IpfsDHT) {
+    it.mu.Lock()
+    it.kdht = kdht
+    it.mu.Unlock()
+    if it.timeout <= 0 {
+        return
+    }
+
+    go func() {
+        ticker := time.NewTicker(it.timeout / 4)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                it.mu.Lock()
+                if it.idle || it.waking != nil || time.Since(it.last) <= it.timeout {
+                    it.mu.Unlock()
+                    continue
+                }
+                it.idle = true
+                sleeping := make(chan struct{})
+                it.sleeping = sleeping
+                it.mu.Unlock()
+
+                it.sleep(kdht)
+                it.mu.Lock()
+                it.sleeping = nil
+                it.mu.Unlock()
+                close(sleeping)
+            case <-it.ctx.Done():
+                return
+            }
+        }
+    }()
+}
+
+func (it *idleTracker) suspended() bool {
+    it.mu.Lock()
+    defer it.mu.Unlock()
+    return it.idle
+}
+
+// touch records activity. If the node was idle it is woken up, and wait
+// makes touch return only once the node is ready for a Put/Get again.
+func (it *idleTracker) touch(wait bool) {
+    it.mu.Lock()
+    it.last = time.Now()
+    if it.idle && it.kdht != nil {
+        waking := make(chan struct{})
+        it.waking = waking
+        go func(kdht *dht.IpfsDHT, sleeping chan struct{}) {
+            // Don't let the sleep close the connections we reopen
+            if sleeping != nil {
+                <-sleeping
+            }
+            it.wake(kdht)
+            it.mu.Lock()
+            it.waking = nil
+            it.mu.Unlock()
+            close(waking)
+        }(it.kdht, it.sleeping)
+    }
+    it.idle = false
+    waking := it.waking
+    it.mu.Unlock()
+
+    // Callers arriving while another one woke the node wait as well
+    if wait && waking != nil {
+        <-waking
+    }
+}
+
+func (it *idleTracker) sleep(kdht *dht.IpfsDHT) {
+    h := kdht.Host()
+    keep := make(map[peer.ID]bool)
+    for _, p := range kdht.RoutingTable().NearestPeers(kdht.PeerKey(), idleKeepPeers) {
+        keep[p] = true
+    }
+
+    closed := 0
+    for _, p := range h.Network().Peers() {
+        if !keep[p] {
+            h.Network().ClosePeer(p)
+            closed++
+        }
+    }
+    log.Printf("Node idle for %s, closed %d connections", it.timeout, closed)
+}
+
+func (it *idleTracker) wake(kdht *dht.IpfsDHT) {
+    log.Printf("Waking up node")
+    if len(it.bootstrapPeers) > 0 {
+        if err := bootstrap(it.ctx, kdht, it.bootstrapPeers); err != nil {
+            log.Printf("Bootstrap: %v", err)
+        }
+    }
+    if err := <-kdht.RefreshRoutingTable(); err != nil {
+        log.Printf("Refresh after waking up: %v", err)
+    }
+}
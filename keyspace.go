@@ -0,0 +1,103 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/libp2p/go-libp2p-kad-dht/amino"
+    kb "github.com/libp2p/go-libp2p-kbucket"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+    // Keys are grouped into 2^regionBits keyspace regions by their
+    // leading bits when looking for hot regions
+    regionBits = 4
+
+    // Number of closest peers listed per key
+    keyInfoPeers = 5
+)
+
+// keyInfo shows where an application key lands in the Kademlia keyspace.
+type keyInfo struct {
+    key    string
+    dhtKey string
+    id     kb.ID
+    region int
+    // Closest known peers, nearest first
+    closest []peer.ID
+}
+
+func newKeyInfo(key string) keyInfo {
+    k := dhtKey(key)
+    id := kb.ConvertKey(k)
+    return keyInfo{key: key, dhtKey: k, id: id, region: int(id[0] >> (8 - regionBits))}
+}
+
+// distance is the XOR distance between a key and a peer.
+func distance(id kb.ID, p peer.ID) []byte {
+    pid := kb.ConvertPeerID(p)
+    d := make([]byte, len(id))
+    for i := range id {
+        d[i] = id[i] ^ pid[i]
+    }
+    return d
+}
+
+func (ki keyInfo) String() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "Key %s (DHT key %s)\n", ki.key, ki.dhtKey)
+    fmt.Fprintf(&b, "  kademlia id: %s\n", hex.EncodeToString(ki.id))
+    fmt.Fprintf(&b, "  region:      %0*b of %d\n", regionBits, ki.region, 1<<regionBits)
+    if len(ki.closest) == 0 {
+        fmt.Fprintf(&b, "  no peers known\n")
+    }
+    for _, p := range ki.closest {
+        fmt.Fprintf(&b, "  peer %s  common prefix %3d bits  distance %s…\n",
+            p, kb.CommonPrefixLen(ki.id, kb.ConvertPeerID(p)), hex.EncodeToString(distance(ki.id, p)[:8]))
+    }
+    return b.String()
+}
+
+// explainKeys prints where each key lands in the keyspace and, for more
+// than one key, how they spread over keyspace regions. Regions holding
+// far more keys than an even spread would are flagged as hot: the peers
+// closest to them store a disproportionate share of the records.
+func explainKeys(n *node, keys []string) {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    if err := waitForPeers(ctx, n.IpfsDHT, 1); err != nil {
+        log.Printf("Routing table is empty, no closest peers to show")
+    }
+
+    counts := make([]int, 1<<regionBits)
+    for _, key := range keys {
+        ki := newKeyInfo(key)
+        peers, err := n.GetClosestPeers(ctx, ki.dhtKey)
+        if err != nil {
+            // Fall back to what the routing table knows
+            peers = n.RoutingTable().NearestPeers(ki.id, amino.DefaultBucketSize)
+        }
+        peers = kb.SortClosestPeers(peers, ki.id)
+        ki.closest = peers[:min(len(peers), keyInfoPeers)]
+        counts[ki.region]++
+        fmt.Print(ki)
+    }
+    if len(keys) < 2 {
+        return
+    }
+
+    expected := float64(len(keys)) / float64(len(counts))
+    fmt.Printf("Spread of %d keys over %d regions (%.1f expected per region):\n", len(keys), len(counts), expected)
+    for region, c := range counts {
+        hot := ""
+        if c >= 3 && float64(c) > 2*expected {
+            hot = "  HOT"
+        }
+        fmt.Printf("  %0*b  %-4d %s%s\n", regionBits, region, c, strings.Repeat("#", c), hot)
+    }
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+    "github.com/libp2p/go-msgio/pbio"
+)
+
+const messageReadTimeout = 10 * time.Second
+
+// messageSender sends DHT wire messages over a fresh stream per message.
+// The DHT is configured to use it so that we get to see how each peer
+// answered our own requests, which the DHT API does not report.
+type messageSender struct {
+    host      host.Host
+    protocols []protocol.ID
+}
+
+func newMessageSender(h host.Host, protos []protocol.ID) *messageSender {
+    return &messageSender{host: h, protocols: protos}
+}
+
+func (ms *messageSender) SendRequest(ctx context.Context, p peer.ID, pmes *pb.Message) (*pb.Message, error) {
+    start := time.Now()
+    resp, err := ms.roundTrip(ctx, p, pmes, true)
+    if err == nil {
+        ms.host.Peerstore().RecordLatency(p, time.Since(start))
+    }
+    observe(ctx, p, pmes, resp, err)
+    return resp, err
+}
+
+func (ms *messageSender) SendMessage(ctx context.Context, p peer.ID, pmes *pb.Message) error {
+    _, err := ms.roundTrip(ctx, p, pmes, false)
+    observe(ctx, p, pmes, nil, err)
+    return err
+}
+
+func (ms *messageSender) OnDisconnect(ctx context.Context, p peer.ID) {
+    // No streams are kept open between messages
+}
+
+func (ms *messageSender) roundTrip(ctx context.Context, p peer.ID, pmes *pb.Message, wantResponse bool) (*pb.Message, error) {
+    s, err := ms.host.NewStream(ctx, p, ms.protocols...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open stream: %w", err)
+    }
+    defer s.Close()
+
+    if err := pbio.NewDelimitedWriter(s).WriteMsg(pmes); err != nil {
+        s.Reset()
+        return nil, fmt.Errorf("failed to write message: %w", err)
+    }
+    if !wantResponse {
+        return nil, nil
+    }
+
+    deadline := time.Now().Add(messageReadTimeout)
+    if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+        deadline = d
+    }
+    s.SetReadDeadline(deadline)
+
+    resp := new(pb.Message)
+    if err := pbio.NewDelimitedReader(s, network.MessageSizeMax).ReadMsg(resp); err != nil {
+        s.Reset()
+        return nil, fmt.Errorf("failed to read response: %w", err)
+    }
+    return resp, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+    "context"
+    "log"
+    "maps"
+    "net"
+    "time"
+
+    dht "github.com/libp2p/go-libp2p-kad-dht"
+    "github.com/libp2p/go-libp2p/core/peer"
+    manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const netCheckInterval = 5 * time.Second
+
+// watchNetwork notices when the machine's interface addresses change, e.g.
+// when a laptop moves from Wi-Fi to LTE. Connections bound to an address
+// that went away are dead, so they are closed to be redialed from the new
+// one. The node then rejoins through its bootstrap peers and refreshes its
+// routing table, so that the closest peers learn the new addresses. The
+// host itself pushes updated addresses to connected peers and reruns its
+// AutoNAT checks when its listen addresses change.
+func watchNetwork(ctx context.Context, kdht *dht.IpfsDHT, bootstrapPeers []peer.AddrInfo) {
+    ticker := time.NewTicker(netCheckInterval)
+    defer ticker.Stop()
+
+    known := localIPs()
+    for {
+        select {
+        case <-ticker.C:
+        case <-ctx.Done():
+            return
+        }
+
+        cur := localIPs()
+        if maps.Equal(known, cur) {
+            continue
+        }
+        known = cur
+        log.Printf("Local network addresses changed, reconnecting")
+
+        closed := 0
+        for _, c := range kdht.Host().Network().Conns() {
+            ip, err := manet.ToIP(c.LocalMultiaddr())
+            if err != nil || ip.IsUnspecified() {
+                continue
+            }
+            if _, ok := cur[ip.String()]; !ok {
+                c.Close()
+                closed++
+            }
+        }
+        if closed > 0 {
+            log.Printf("Closed %d connections on addresses that went away", closed)
+        }
+
+        if len(bootstrapPeers) > 0 {
+            if err := bootstrap(ctx, kdht, bootstrapPeers); err != nil {
+                log.Printf("Bootstrap: %v", err)
+            }
+        }
+        if err := <-kdht.RefreshRoutingTable(); err != nil {
+            log.Printf("Routing table refresh failed: %v", err)
+        }
+    }
+}
+
+func localIPs() map[string]struct{} {
+    ips := make(map[string]struct{})
+    addrs, err := net.InterfaceAddrs()
+    if err != nil {
+        return ips
+    }
+    for _, a := range addrs {
+        if ipnet, ok := a.(*net.IPNet); ok {
+            ips[ipnet.IP.String()] = struct{}{}
+        }
+    }
+    return ips
+}
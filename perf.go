@@ -0,0 +1,238 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "log"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+    perfEchoProtocol      = dhtProtocolPrefix + "/perf/echo/1.0.0"
+    perfPayloadProtocol   = dhtProtocolPrefix + "/perf/payload/1.0.0"
+    perfBandwidthProtocol = dhtProtocolPrefix + "/perf/bandwidth/1.0.0"
+
+    // Limits for serving other nodes
+    perfMaxStreamTime = 5 * time.Minute
+    perfMaxPayload    = 64 << 20
+
+    perfEchoSize    = 64
+    perfEchoTimeout = 2 * time.Second
+    perfPayloadSize = 1 << 20
+    perfChunkSize   = 64 << 10
+)
+
+var perfZeros = make([]byte, perfChunkSize)
+
+// servePerf answers the perf protocols: echo copies the stream back,
+// payload sends the requested number of bytes and bandwidth discards what
+// it receives and replies with the byte count.
+func servePerf(h host.Host) {
+    h.SetStreamHandler(perfEchoProtocol, func(s network.Stream) {
+        defer s.Close()
+        s.SetDeadline(time.Now().Add(perfMaxStreamTime))
+        io.Copy(s, s)
+    })
+    h.SetStreamHandler(perfPayloadProtocol, func(s network.Stream) {
+        defer s.Close()
+        s.SetDeadline(time.Now().Add(perfMaxStreamTime))
+        var size uint64
+        if err := binary.Read(s, binary.BigEndian, &size); err != nil || size > perfMaxPayload {
+            s.Reset()
+            return
+        }
+        if err := writeZeros(s, int64(size)); err != nil {
+            s.Reset()
+        }
+    })
+    h.SetStreamHandler(perfBandwidthProtocol, func(s network.Stream) {
+        defer s.Close()
+        s.SetDeadline(time.Now().Add(perfMaxStreamTime))
+        received, err := io.Copy(io.Discard, s)
+        if err != nil {
+            s.Reset()
+            return
+        }
+        binary.Write(s, binary.BigEndian, uint64(received))
+    })
+}
+
+func writeZeros(w io.Writer, n int64) error {
+    for n > 0 {
+        chunk := min(n, perfChunkSize)
+        if _, err := w.Write(perfZeros[:chunk]); err != nil {
+            return err
+        }
+        n -= chunk
+    }
+    return nil
+}
+
+// perf measures round trips, downloads and uploads against target for a
+// third of the duration each. The target must run with -perf.
+func perf(n *node, target string, duration time.Duration) error {
+    ctx, cancel := context.WithTimeout(context.Background(), duration+probeTimeout)
+    defer cancel()
+
+    pi, err := resolvePeer(ctx, n, target)
+    if err != nil {
+        return err
+    }
+    if err := n.Host().Connect(ctx, pi); err != nil {
+        return fmt.Errorf("failed to connect to %s: %w", pi.ID, err)
+    }
+    fmt.Printf("Measuring %s for %s\n", pi.ID, duration)
+
+    phase := duration / 3
+    if err := perfEcho(ctx, n.Host(), pi.ID, phase); err != nil {
+        return fmt.Errorf("echo: %w", err)
+    }
+    if err := perfDownload(ctx, n.Host(), pi.ID, phase); err != nil {
+        return fmt.Errorf("download: %w", err)
+    }
+    if err := perfUpload(ctx, n.Host(), pi.ID, phase); err != nil {
+        return fmt.Errorf("upload: %w", err)
+    }
+    return nil
+}
+
+// perfEcho sends small numbered messages one after another. A message that
+// doesn't come back intact within perfEchoTimeout counts as lost, and the
+// stream is replaced.
+func perfEcho(ctx context.Context, h host.Host, p peer.ID, d time.Duration) error {
+    var (
+        s          network.Stream
+        sent, lost int
+        total      time.Duration
+    )
+    msg := make([]byte, perfEchoSize)
+    reply := make([]byte, perfEchoSize)
+
+    for end := time.Now().Add(d); time.Now().Before(end); {
+        if s == nil {
+            var err error
+            if s, err = h.NewStream(ctx, p, perfEchoProtocol); err != nil {
+                return err
+            }
+        }
+
+        binary.BigEndian.PutUint64(msg, uint64(sent))
+        sent++
+        start := time.Now()
+        s.SetDeadline(start.Add(perfEchoTimeout))
+        _, err := s.Write(msg)
+        if err == nil {
+            _, err = io.ReadFull(s, reply)
+        }
+        if err != nil || !bytes.Equal(msg, reply) {
+            lost++
+            s.Reset()
+            s = nil
+            continue
+        }
+        total += time.Since(start)
+    }
+    if s != nil {
+        s.Close()
+    }
+
+    var avg time.Duration
+    if sent > lost {
+        avg = total / time.Duration(sent-lost)
+    }
+    fmt.Printf("Echo      %d round trips, %d lost (%.1f%%), avg rtt %s\n",
+        sent, lost, 100*float64(lost)/float64(max(sent, 1)), avg.Round(time.Microsecond))
+    return nil
+}
+
+// perfDownload fetches fixed-size payloads back to back. Payloads that
+// arrive short count as failed.
+func perfDownload(ctx context.Context, h host.Host, p peer.ID, d time.Duration) error {
+    var (
+        done, failed int
+        received     int64
+    )
+    start := time.Now()
+    for end := start.Add(d); time.Now().Before(end); {
+        s, err := h.NewStream(ctx, p, perfPayloadProtocol)
+        if err != nil {
+            return err
+        }
+        s.SetDeadline(end.Add(probeTimeout))
+        if err := binary.Write(s, binary.BigEndian, uint64(perfPayloadSize)); err != nil {
+            s.Reset()
+            return err
+        }
+        s.CloseWrite()
+
+        got, err := io.Copy(io.Discard, s)
+        received += got
+        if err != nil || got != perfPayloadSize {
+            failed++
+            s.Reset()
+            continue
+        }
+        done++
+        s.Close()
+    }
+
+    fmt.Printf("Download  %d payloads of %d KiB, %d failed, %.1f Mbit/s\n",
+        done, perfPayloadSize>>10, failed, mbitPerSec(received, time.Since(start)))
+    return nil
+}
+
+// perfUpload writes to the peer for the whole duration and compares what
+// was sent with what the peer says it received.
+func perfUpload(ctx context.Context, h host.Host, p peer.ID, d time.Duration) error {
+    s, err := h.NewStream(ctx, p, perfBandwidthProtocol)
+    if err != nil {
+        return err
+    }
+    defer s.Close()
+
+    start := time.Now()
+    end := start.Add(d)
+    s.SetDeadline(end.Add(probeTimeout))
+
+    var sent int64
+    for time.Now().Before(end) {
+        n, err := s.Write(perfZeros)
+        sent += int64(n)
+        if err != nil {
+            s.Reset()
+            return err
+        }
+    }
+    if err := s.CloseWrite(); err != nil {
+        s.Reset()
+        return err
+    }
+
+    var received uint64
+    if err := binary.Read(s, binary.BigEndian, &received); err != nil {
+        s.Reset()
+        return fmt.Errorf("no byte count from peer: %w", err)
+    }
+    elapsed := time.Since(start)
+    if int64(received) != sent {
+        log.Printf("Peer received %d of %d bytes", received, sent)
+    }
+
+    fmt.Printf("Upload    %d MiB sent, %d MiB received, %.1f Mbit/s\n",
+        sent>>20, received>>20, mbitPerSec(int64(received), elapsed))
+    return nil
+}
+
+func mbitPerSec(bytes int64, d time.Duration) float64 {
+    if d <= 0 {
+        return 0
+    }
+    return float64(bytes) * 8 / 1e6 / d.Seconds()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+    "context"
+    "log"
+    "sync"
+    "time"
+
+    dht "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+const (
+    // A key is prefetched once it followed the current key this many times
+    // and in at least this share of all accesses after it
+    prefetchMinSeen  = 2
+    prefetchMinShare = 0.5
+
+    prefetchTTL     = 30 * time.Second
+    prefetchTimeout = 10 * time.Second
+    // Keys whose successors are tracked
+    prefetchMaxKeys = 4096
+    // Values kept until they are read or expire
+    prefetchMaxCached = 1024
+)
+
+// prefetcher learns which key tends to be read after which, e.g. a
+// manifest before its chunks, and resolves the likely next keys in the
+// background so that reading them doesn't wait for a DHT lookup. Accesses
+// from all clients form one sequence.
+type prefetcher struct {
+    ctx    context.Context
+    lookup func(ctx context.Context, key string) ([]byte, error)
+
+    mu       sync.Mutex
+    last     string
+    next     map[string]map[string]int
+    cache    map[string]prefetched
+    inflight map[string]bool
+    // Bumped by invalidate while a fetch of the key is running, so that
+    // the fetch drops the value it read before the write
+    gen map[string]uint64
+}
+
+type prefetched struct {
+    value   []byte
+    fetched time.Time
+}
+
+func newPrefetcher(ctx context.Context, kdht *dht.IpfsDHT) *prefetcher {
+    lookup := func(ctx context.Context, key string) ([]byte, error) {
+        return kdht.GetValue(ctx, key)
+    }
+    return &prefetcher{
+        ctx:      ctx,
+        lookup:   lookup,
+        next:     make(map[string]map[string]int),
+        cache:    make(map[string]prefetched),
+        inflight: make(map[string]bool),
+        gen:      make(map[string]uint64),
+    }
+}
+
+// cached returns a value fetched ahead for key, at most once.
+func (pf *prefetcher) cached(key string) ([]byte, bool) {
+    pf.mu.Lock()
+    defer pf.mu.Unlock()
+
+    c, ok := pf.cache[key]
+    delete(pf.cache, key)
+    if !ok || time.Since(c.fetched) > prefetchTTL {
+        return nil, false
+    }
+    return c.value, true
+}
+
+// accessed records a read of key and starts fetching the keys that are
+// likely to be read next.
+func (pf *prefetcher) accessed(key string) {
+    pf.mu.Lock()
+    defer pf.mu.Unlock()
+
+    if pf.last != "" && pf.last != key {
+        counts, ok := pf.next[pf.last]
+        if !ok {
+            if len(pf.next) >= prefetchMaxKeys {
+                for k := range pf.next {
+                    delete(pf.next, k)
+                    break
+                }
+            }
+            counts = make(map[string]int)
+            pf.next[pf.last] = counts
+        }
+        counts[key]++
+    }
+    pf.last = key
+
+    total := 0
+    for _, c := range pf.next[key] {
+        total += c
+    }
+    for k, c := range pf.next[key] {
+        if c < prefetchMinSeen || float64(c) < prefetchMinShare*float64(total) || pf.inflight[k] {
+            continue
+        }
+        if _, ok := pf.cache[k]; ok {
+            continue
+        }
+        pf.inflight[k] = true
+        go pf.fetch(k, pf.gen[k])
+    }
+}
+
+// invalidate drops a prefetched value that a write made stale.
+func (pf *prefetcher) invalidate(key string) {
+    pf.mu.Lock()
+    defer pf.mu.Unlock()
+    delete(pf.cache, key)
+    if pf.inflight[key] {
+        pf.gen[key]++
+    }
+}
+
+// fetch resolves key and caches its value unless the key was invalidated
+// since gen was read.
+func (pf *prefetcher) fetch(key string, gen uint64) {
+    ctx, cancel := context.WithTimeout(pf.ctx, prefetchTimeout)
+    defer cancel()
+    raw, err := pf.lookup(ctx, dhtKey(key))
+
+    pf.mu.Lock()
+    defer pf.mu.Unlock()
+    stale := pf.gen[key] != gen
+    delete(pf.inflight, key)
+    delete(pf.gen, key)
+    if err != nil {
+        log.Printf("Prefetch of %s failed: %v", key, err)
+        return
+    }
+    if stale {
+        return
+    }
+    _, val, _ := decodeValue(raw)
+    pf.store(key, val, time.Now())
+}
+
+// store caches a fetched value, first dropping expired values and, if the
+// cache is still full, the oldest one. Callers hold pf.mu.
+func (pf *prefetcher) store(key string, val []byte, now time.Time) {
+    var oldest string
+    for k, c := range pf.cache {
+        if now.Sub(c.fetched) > prefetchTTL {
+            delete(pf.cache, k)
+        } else if oldest == "" || c.fetched.Before(pf.cache[oldest].fetched) {
+            oldest = k
+        }
+    }
+    if len(pf.cache) >= prefetchMaxCached {
+        delete(pf.cache, oldest)
+    }
+    pf.cache[key] = prefetched{value: val, fetched: now}
+}
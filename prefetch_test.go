@@ -0,0 +1,149 @@
+package main
+
+import (
+    "context"
+    "strings"
+    "testing"
+    "time"
+)
+
+// fakeLookup answers prefetches with a value per key once released.
+type fakeLookup struct {
+    started chan string
+    release chan []byte
+}
+
+func newTestPrefetcher() (*prefetcher, *fakeLookup) {
+    fl := &fakeLookup{started: make(chan string, 16), release: make(chan []byte)}
+    pf := newPrefetcher(context.Background(), nil)
+    pf.lookup = func(ctx context.Context, key string) ([]byte, error) {
+        fl.started <- strings.TrimPrefix(key, dhtKey(""))
+        return <-fl.release, nil
+    }
+    return pf, fl
+}
+
+// waitIdle waits for running fetches to store their results.
+func waitIdle(t *testing.T, pf *prefetcher) {
+    t.Helper()
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        pf.mu.Lock()
+        n := len(pf.inflight)
+        pf.mu.Unlock()
+        if n == 0 {
+            return
+        }
+        if time.Now().After(deadline) {
+            t.Fatal("fetches still running")
+        }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+func TestPrefetchAccessed(t *testing.T) {
+    tests := []struct {
+        name     string
+        accesses []string
+        wantKey  string // fetched on the last access, "" for none
+    }{
+        {"seen once", []string{"a", "b", "a"}, ""},
+        {"seen twice", []string{"a", "b", "a", "b", "a"}, "b"},
+        {"no clear successor", []string{"a", "b", "a", "c", "a", "d", "a", "e", "a", "b", "a", "c", "a"}, ""},
+        {"majority successor", []string{"a", "b", "a", "b", "a", "c", "a"}, "b"},
+        {"repeated key", []string{"a", "a", "a", "a"}, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pf, fl := newTestPrefetcher()
+            for _, k := range tt.accesses {
+                pf.accessed(k)
+            }
+
+            select {
+            case got := <-fl.started:
+                if got != tt.wantKey {
+                    t.Fatalf("fetched %q, want %q", got, tt.wantKey)
+                }
+                fl.release <- encodeValue(1, []byte("v"))
+                waitIdle(t, pf)
+            case <-time.After(50 * time.Millisecond):
+                if tt.wantKey != "" {
+                    t.Fatalf("nothing fetched, want %q", tt.wantKey)
+                }
+            }
+        })
+    }
+}
+
+func TestPrefetchCached(t *testing.T) {
+    pf, fl := newTestPrefetcher()
+    for _, k := range []string{"a", "b", "a", "b", "a"} {
+        pf.accessed(k)
+    }
+    <-fl.started
+    fl.release <- encodeValue(1, []byte("manifest"))
+    waitIdle(t, pf)
+
+    if val, ok := pf.cached("b"); !ok || string(val) != "manifest" {
+        t.Fatalf("cached(b) = %q, %v, want manifest", val, ok)
+    }
+    if _, ok := pf.cached("b"); ok {
+        t.Error("prefetched value returned twice")
+    }
+    if _, ok := pf.cached("a"); ok {
+        t.Error("value returned for a key that wasn't prefetched")
+    }
+}
+
+func TestPrefetchExpired(t *testing.T) {
+    pf, _ := newTestPrefetcher()
+    pf.store("a", []byte("old"), time.Now().Add(-prefetchTTL-time.Second))
+    if _, ok := pf.cached("a"); ok {
+        t.Error("expired value returned")
+    }
+}
+
+func TestPrefetchInvalidateInflight(t *testing.T) {
+    pf, fl := newTestPrefetcher()
+    for _, k := range []string{"a", "b", "a", "b", "a"} {
+        pf.accessed(k)
+    }
+    <-fl.started
+
+    // A write lands while the fetch is still waiting for the old value
+    pf.invalidate("b")
+    fl.release <- encodeValue(1, []byte("stale"))
+    waitIdle(t, pf)
+
+    if val, ok := pf.cached("b"); ok {
+        t.Errorf("cached(b) = %q after invalidate, want nothing", val)
+    }
+    if len(pf.gen) != 0 {
+        t.Errorf("%d generations left after the fetch finished", len(pf.gen))
+    }
+}
+
+func TestPrefetchStoreEvicts(t *testing.T) {
+    pf, _ := newTestPrefetcher()
+    now := time.Now()
+    pf.store("expired", []byte("x"), now.Add(-prefetchTTL-time.Second))
+    for i := 0; i < prefetchMaxCached; i++ {
+        pf.store(string(rune('a'+i%26))+strings.Repeat("x", i/26), []byte("x"), now.Add(time.Duration(i)))
+    }
+
+    if len(pf.cache) != prefetchMaxCached {
+        t.Fatalf("cache holds %d values, want %d", len(pf.cache), prefetchMaxCached)
+    }
+    if _, ok := pf.cache["expired"]; ok {
+        t.Error("expired value kept")
+    }
+
+    pf.store("new", []byte("x"), now.Add(time.Second))
+    if len(pf.cache) != prefetchMaxCached {
+        t.Errorf("cache holds %d values after a full insert, want %d", len(pf.cache), prefetchMaxCached)
+    }
+    if _, ok := pf.cache["a"]; ok {
+        t.Error("oldest value kept when the cache was full")
+    }
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "slices"
+    "os"
+    "strings"
+    "text/tabwriter"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/event"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+    "github.com/libp2p/go-libp2p/p2p/protocol/identify"
+    "github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+const probeTimeout = 10 * time.Second
+
+// probeCheck exercises one protocol on the probed peer with a tiny request
+// and describes the result.
+type probeCheck struct {
+    proto protocol.ID
+    run   func(ctx context.Context, n *node, p peer.ID) (string, error)
+}
+
+func probeChecks() []probeCheck {
+    return []probeCheck{
+        {identify.ID, func(ctx context.Context, n *node, p peer.ID) (string, error) {
+            agent, _ := n.Host().Peerstore().Get(p, "AgentVersion")
+            return fmt.Sprintf("agent %v", agent), nil
+        }},
+        {ping.ID, func(ctx context.Context, n *node, p peer.ID) (string, error) {
+            res := <-ping.Ping(ctx, n.Host(), p)
+            if res.Error != nil {
+                return "", res.Error
+            }
+            return fmt.Sprintf("rtt %s", res.RTT.Round(time.Microsecond)), nil
+        }},
+        {kadProtocol, func(ctx context.Context, n *node, p peer.ID) (string, error) {
+            closer, err := n.messenger.GetClosestPeers(ctx, p, n.PeerID())
+            if err != nil {
+                return "", err
+            }
+            // A GET_VALUE for a key nobody stores still has to be answered
+            if _, _, err := n.messenger.GetValue(ctx, p, dhtKey("probe")); err != nil {
+                return "", fmt.Errorf("find node ok, get value: %w", err)
+            }
+            return fmt.Sprintf("find node and get value ok, %d closer peers", len(closer)), nil
+        }},
+    }
+}
+
+// probe connects to target, a multiaddr with a /p2p/ part or a bare peer
+// ID looked up in the DHT, and prints which protocols it supports and how
+// the ones we know answered a tiny request.
+func probe(n *node, target string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+    defer cancel()
+
+    pi, err := resolvePeer(ctx, n, target)
+    if err != nil {
+        return err
+    }
+
+    // Protocols are only known once identify finished
+    sub, err := n.Host().EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+    if err != nil {
+        return err
+    }
+    defer sub.Close()
+
+    start := time.Now()
+    if err := n.Host().Connect(ctx, pi); err != nil {
+        return fmt.Errorf("failed to connect to %s: %w", pi.ID, err)
+    }
+    fmt.Printf("Connected to %s in %s\n", pi.ID, time.Since(start).Round(time.Millisecond))
+
+    if protos, _ := n.Host().Peerstore().GetProtocols(pi.ID); len(protos) == 0 {
+        if err := waitIdentified(ctx, sub, pi.ID); err != nil {
+            return err
+        }
+    }
+
+    protos, err := n.Host().Peerstore().GetProtocols(pi.ID)
+    if err != nil {
+        return err
+    }
+    slices.Sort(protos)
+
+    tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(tw, "PROTOCOL\tADVERTISED\tCHECK")
+    checked := make(map[protocol.ID]bool)
+    for _, c := range probeChecks() {
+        checked[c.proto] = true
+        advertised := slices.Contains(protos, c.proto)
+        result, err := c.run(ctx, n, pi.ID)
+        if err != nil {
+            result = "failed: " + err.Error()
+        }
+        fmt.Fprintf(tw, "%s\t%s\t%s\n", c.proto, yesNo(advertised), result)
+    }
+    for _, proto := range protos {
+        if !checked[proto] {
+            fmt.Fprintf(tw, "%s\t%s\t%s\n", proto, "yes", "not checked")
+        }
+    }
+    return tw.Flush()
+}
+
+// resolvePeer turns a multiaddr with a /p2p/ part or a bare peer ID into
+// the peer's addresses, looking the ID up in the DHT.
+func resolvePeer(ctx context.Context, n *node, target string) (peer.AddrInfo, error) {
+    if strings.HasPrefix(target, "/") {
+        info, err := peer.AddrInfoFromString(target)
+        if err != nil {
+            return peer.AddrInfo{}, fmt.Errorf("invalid peer address: %w", err)
+        }
+        return *info, nil
+    }
+    id, err := peer.Decode(target)
+    if err != nil {
+        return peer.AddrInfo{}, fmt.Errorf("invalid peer ID: %w", err)
+    }
+    pi, err := n.FindPeer(ctx, id)
+    if err != nil {
+        return peer.AddrInfo{}, fmt.Errorf("failed to find %s: %w", id, err)
+    }
+    return pi, nil
+}
+
+func waitIdentified(ctx context.Context, sub event.Subscription, p peer.ID) error {
+    for {
+        select {
+        case e := <-sub.Out():
+            if e.(event.EvtPeerIdentificationCompleted).Peer == p {
+                return nil
+            }
+        case <-ctx.Done():
+            return fmt.Errorf("identify did not complete: %w", ctx.Err())
+        }
+    }
+}
+
+func yesNo(b bool) string {
+    if b {
+        return "yes"
+    }
+    return "no"
+}
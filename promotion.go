@@ -0,0 +1,110 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "reflect"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/event"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/p2p/host/eventbus"
+)
+
+// stableHost gives the DHT its own event bus. Everything is forwarded from
+// the real host bus as is, except that a switch to public reachability is
+// held back until it lasted for the whole promotion period. In ModeAuto the
+// DHT therefore stays a client until the node proved to be stable.
+type stableHost struct {
+    host.Host
+    bus event.Bus
+}
+
+func (h *stableHost) EventBus() event.Bus {
+    return h.bus
+}
+
+// Events the DHT subscribes to, other than reachability changes
+var forwardedEvents = []interface{}{
+    new(event.EvtPeerIdentificationCompleted),
+    new(event.EvtPeerProtocolsUpdated),
+    new(event.EvtLocalAddressesUpdated),
+    new(event.EvtPeerConnectednessChanged),
+}
+
+func newStableHost(ctx context.Context, h host.Host, promoteAfter time.Duration) (*stableHost, error) {
+    bus := eventbus.NewBus()
+
+    emitters := make(map[reflect.Type]event.Emitter)
+    for _, evt := range forwardedEvents {
+        em, err := bus.Emitter(evt)
+        if err != nil {
+            return nil, fmt.Errorf("failed to create emitter: %w", err)
+        }
+        emitters[reflect.TypeOf(evt).Elem()] = em
+    }
+    reachability, err := bus.Emitter(new(event.EvtLocalReachabilityChanged), eventbus.Stateful)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create emitter: %w", err)
+    }
+
+    sub, err := h.EventBus().Subscribe(
+        append(forwardedEvents, new(event.EvtLocalReachabilityChanged)),
+        eventbus.BufSize(256),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("failed to subscribe to host events: %w", err)
+    }
+
+    go func() {
+        defer sub.Close()
+
+        // Fires once the node has been public for promoteAfter
+        var promote <-chan time.Time
+        var timer *time.Timer
+
+        for {
+            select {
+            case e, ok := <-sub.Out():
+                if !ok {
+                    return
+                }
+                evt, isReachability := e.(event.EvtLocalReachabilityChanged)
+                if !isReachability {
+                    if em, ok := emitters[reflect.TypeOf(e)]; ok {
+                        em.Emit(e)
+                    }
+                    continue
+                }
+
+                if timer != nil {
+                    timer.Stop()
+                    timer, promote = nil, nil
+                }
+                if evt.Reachability == network.ReachabilityPublic {
+                    log.Printf("Node is publicly reachable, promoting to DHT server in %s", promoteAfter)
+                    timer = time.NewTimer(promoteAfter)
+                    promote = timer.C
+                    continue
+                }
+                // Demotion is never delayed
+                reachability.Emit(evt)
+
+            case <-promote:
+                timer, promote = nil, nil
+                log.Printf("Node stayed publicly reachable, promoting to DHT server")
+                reachability.Emit(event.EvtLocalReachabilityChanged{Reachability: network.ReachabilityPublic})
+
+            case <-ctx.Done():
+                if timer != nil {
+                    timer.Stop()
+                }
+                return
+            }
+        }
+    }()
+
+    return &stableHost{Host: h, bus: bus}, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    kb "github.com/libp2p/go-libp2p-kbucket"
+    recpb "github.com/libp2p/go-libp2p-record/pb"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const explainTimeout = 30 * time.Second
+
+// provenance describes where a value came from, as seen by asking each of
+// the peers closest to the key for its copy of the record.
+type provenance struct {
+    key   string
+    value []byte
+    // When the writer stored the value, taken from its sequence number
+    written  time.Time
+    servedBy peer.ID
+    // Time the serving peer received the record, zero if unknown
+    received time.Time
+
+    corroborated []peer.ID
+    conflicting  []peer.ID
+    missing      []peer.ID
+    // Peers that returned a record the validator rejects
+    invalid map[peer.ID]error
+}
+
+// explain asks the peers closest to key for their record and reports
+// which one serves the best value and how many others agree with it.
+func explain(n *node, key string) (*provenance, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+    defer cancel()
+
+    k := dhtKey(key)
+    peers, err := n.GetClosestPeers(ctx, k)
+    if err != nil {
+        return nil, fmt.Errorf("failed to find peers for %s: %w", key, err)
+    }
+    peers = kb.SortClosestPeers(peers, kb.ConvertKey(k))
+
+    var (
+        mu      sync.Mutex
+        wg      sync.WaitGroup
+        records = make(map[peer.ID]*recpb.Record)
+    )
+    for _, p := range peers {
+        wg.Add(1)
+        go func(p peer.ID) {
+            defer wg.Done()
+            rec, _, err := n.messenger.GetValue(ctx, p, k)
+            if err != nil || rec == nil {
+                return
+            }
+            mu.Lock()
+            records[p] = rec
+            mu.Unlock()
+        }(p)
+    }
+    wg.Wait()
+
+    pv := &provenance{key: key, invalid: make(map[peer.ID]error)}
+    var values [][]byte
+    for _, p := range peers {
+        rec, ok := records[p]
+        if !ok {
+            pv.missing = append(pv.missing, p)
+            continue
+        }
+        // /myapp records are not signed, validation is all there is to check
+        if err := n.Validator.Validate(k, rec.GetValue()); err != nil {
+            pv.invalid[p] = err
+            continue
+        }
+        values = append(values, rec.GetValue())
+    }
+    if len(values) == 0 {
+        return pv, nil
+    }
+    best, err := n.Validator.Select(k, values)
+    if err != nil {
+        return nil, err
+    }
+    raw := values[best]
+    seq, value, _ := decodeValue(raw)
+    pv.value, pv.written = value, time.Unix(0, int64(seq))
+
+    // The closest peer holding the best value serves it
+    for _, p := range peers {
+        rec, ok := records[p]
+        if _, invalid := pv.invalid[p]; !ok || invalid {
+            continue
+        }
+        switch {
+        case !bytes.Equal(rec.GetValue(), raw):
+            pv.conflicting = append(pv.conflicting, p)
+        case pv.servedBy == "":
+            pv.servedBy = p
+            pv.received, _ = time.Parse(time.RFC3339Nano, rec.GetTimeReceived())
+        default:
+            pv.corroborated = append(pv.corroborated, p)
+        }
+    }
+    return pv, nil
+}
+
+func (pv *provenance) String() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "Provenance of key=%s:\n", pv.key)
+    if pv.servedBy == "" {
+        fmt.Fprintf(&b, "  no peer holds a valid record (%d asked)\n", len(pv.missing)+len(pv.invalid))
+        pv.writeInvalid(&b)
+        return b.String()
+    }
+
+    fmt.Fprintf(&b, "  value:        %s\n", pv.value)
+    fmt.Fprintf(&b, "  written:      %s\n", pv.written.Format(time.RFC3339))
+    fmt.Fprintf(&b, "  served by:    %s\n", pv.servedBy)
+    if pv.received.IsZero() {
+        fmt.Fprintf(&b, "  age:          unknown\n")
+    } else {
+        fmt.Fprintf(&b, "  age:          %s (received %s)\n", time.Since(pv.received).Round(time.Second), pv.received.Format(time.RFC3339))
+    }
+    fmt.Fprintf(&b, "  corroborated: %d peers\n", len(pv.corroborated))
+    fmt.Fprintf(&b, "  conflicting:  %d peers\n", len(pv.conflicting))
+    fmt.Fprintf(&b, "  missing:      %d peers\n", len(pv.missing))
+    pv.writeInvalid(&b)
+    return b.String()
+}
+
+func (pv *provenance) writeInvalid(b *strings.Builder) {
+    fmt.Fprintf(b, "  invalid:      %d peers\n", len(pv.invalid))
+    for p, err := range pv.invalid {
+        fmt.Fprintf(b, "    %s: %v\n", p, err)
+    }
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "strconv"
+    "strings"
+)
+
+const (
+    // Largest bulk string we accept, a little above the largest valid value
+    maxBulkLen = maxValueSize + 1024
+    // Longest line, which an inline SET has to fit in
+    maxLineLen = maxBulkLen
+    // Most arguments in a command, SET takes the most with three
+    maxCommandArgs = 16
+)
+
+// serveRedis answers a subset of the Redis protocol (RESP) on ln, mapping
+// GET and SET onto the DHT. Each client connection gets its own session, so
+// a client always reads its own writes. The DHT cannot delete values and
+// the node has no pubsub, so DEL and SUBSCRIBE are refused.
+func serveRedis(ln net.Listener, n *node, minReplicas int) error {
+    for {
+        conn, err := ln.Accept()
+        if err != nil {
+            if errors.Is(err, net.ErrClosed) {
+                return nil
+            }
+            return err
+        }
+        go handleRedisConn(conn, newSession(n), minReplicas)
+    }
+}
+
+// serveRedisUntil serves Redis clients on addr until ctx is done.
+func serveRedisUntil(ctx context.Context, addr string, n *node, minReplicas int) error {
+    ln, err := net.Listen("tcp", addr)
+    if err != nil {
+        return fmt.Errorf("failed to listen for Redis clients: %w", err)
+    }
+    fmt.Printf("Serving Redis protocol on %s\n", ln.Addr())
+
+    errc := make(chan error, 1)
+    go func() {
+        errc <- serveRedis(ln, n, minReplicas)
+    }()
+    select {
+    case <-ctx.Done():
+        ln.Close()
+        return nil
+    case err := <-errc:
+        ln.Close()
+        return fmt.Errorf("redis listener: %w", err)
+    }
+}
+
+func handleRedisConn(conn net.Conn, s *session, minReplicas int) {
+    defer conn.Close()
+    r := bufio.NewReader(conn)
+    w := bufio.NewWriter(conn)
+
+    for {
+        args, err := readCommand(r)
+        if err != nil {
+            if !errors.Is(err, io.EOF) {
+                log.Printf("Redis client %s: %v", conn.RemoteAddr(), err)
+                fmt.Fprintf(w, "-ERR Protocol error: %v\r\n", err)
+                w.Flush()
+            }
+            return
+        }
+        if len(args) == 0 {
+            continue
+        }
+
+        switch cmd := strings.ToUpper(args[0]); {
+        case cmd == "PING":
+            w.WriteString("+PONG\r\n")
+        case cmd == "QUIT":
+            w.WriteString("+OK\r\n")
+            w.Flush()
+            return
+        case cmd == "GET" && len(args) == 2:
+            val := s.get(args[1])
+            if val == nil {
+                w.WriteString("$-1\r\n")
+            } else {
+                fmt.Fprintf(w, "$%d\r\n%s\r\n", len(val), val)
+            }
+        case cmd == "SET" && len(args) == 3:
+            if _, err := s.put(args[1], []byte(args[2]), minReplicas); err != nil {
+                fmt.Fprintf(w, "-ERR put failed: %s\r\n", strings.ReplaceAll(err.Error(), "\n", " "))
+            } else {
+                w.WriteString("+OK\r\n")
+            }
+        case cmd == "GET" || cmd == "SET":
+            fmt.Fprintf(w, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(cmd))
+        case cmd == "DEL":
+            w.WriteString("-ERR DEL is not supported, DHT records cannot be deleted\r\n")
+        case cmd == "SUBSCRIBE":
+            w.WriteString("-ERR SUBSCRIBE is not supported, the node has no pubsub\r\n")
+        default:
+            fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+        }
+        if err := w.Flush(); err != nil {
+            return
+        }
+    }
+}
+
+// readCommand reads one command, either as a RESP array of bulk strings or
+// as an inline command as typed into telnet.
+func readCommand(r *bufio.Reader) ([]string, error) {
+    line, err := readLine(r)
+    if err != nil {
+        return nil, err
+    }
+    if !strings.HasPrefix(line, "*") {
+        args := strings.Fields(line)
+        if len(args) > maxCommandArgs {
+            return nil, fmt.Errorf("too many arguments, limit is %d", maxCommandArgs)
+        }
+        return args, nil
+    }
+
+    count, err := strconv.Atoi(line[1:])
+    if err != nil || count < 0 || count > maxCommandArgs {
+        return nil, fmt.Errorf("invalid array header %q", line)
+    }
+    args := make([]string, 0, count)
+    for i := 0; i < count; i++ {
+        header, err := readLine(r)
+        if err != nil {
+            return nil, noEOF(err)
+        }
+        if !strings.HasPrefix(header, "$") {
+            return nil, fmt.Errorf("expected bulk string, got %q", header)
+        }
+        size, err := strconv.Atoi(header[1:])
+        if err != nil || size < 0 || size > maxBulkLen {
+            return nil, fmt.Errorf("invalid bulk string length %q", header)
+        }
+        buf := make([]byte, size+2)
+        if _, err := io.ReadFull(r, buf); err != nil {
+            return nil, noEOF(err)
+        }
+        if string(buf[size:]) != "\r\n" {
+            return nil, errors.New("bulk string not terminated by CRLF")
+        }
+        args = append(args, string(buf[:size]))
+    }
+    return args, nil
+}
+
+// readLine reads up to the next newline and fails on lines longer than
+// maxLineLen instead of buffering them.
+func readLine(r *bufio.Reader) (string, error) {
+    var line []byte
+    for {
+        chunk, err := r.ReadSlice('\n')
+        if len(line)+len(chunk) > maxLineLen+2 {
+            return "", fmt.Errorf("line longer than %d bytes", maxLineLen)
+        }
+        line = append(line, chunk...)
+        if err == nil {
+            return strings.TrimRight(string(line), "\r\n"), nil
+        }
+        if !errors.Is(err, bufio.ErrBufferFull) {
+            if len(line) > 0 {
+                return "", noEOF(err)
+            }
+            return "", err
+        }
+    }
+}
+
+// noEOF turns an EOF in the middle of a command into an unexpected one, so
+// it isn't mistaken for the client hanging up between commands.
+func noEOF(err error) error {
+    if errors.Is(err, io.EOF) {
+        return io.ErrUnexpectedEOF
+    }
+    return err
+}
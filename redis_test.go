@@ -0,0 +1,60 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "io"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestReadCommand(t *testing.T) {
+    tests := []struct {
+        name    string
+        input   string
+        want    []string
+        wantErr bool
+    }{
+        {"resp array", "*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n", []string{"SET", "foo", "bar"}, false},
+        {"resp empty bulk", "*2\r\n$3\r\nGET\r\n$0\r\n\r\n", []string{"GET", ""}, false},
+        {"resp empty array", "*0\r\n", []string{}, false},
+        {"inline", "GET foo\r\n", []string{"GET", "foo"}, false},
+        {"inline without cr", "PING\n", []string{"PING"}, false},
+        {"huge count", "*999999999999999\r\n", nil, true},
+        {"count above limit", "*100000000\r\n", nil, true},
+        {"negative count", "*-1\r\n", nil, true},
+        {"negative bulk length", "*1\r\n$-5\r\n", nil, true},
+        {"oversized bulk length", "*1\r\n$999999999\r\n", nil, true},
+        {"not a bulk string", "*1\r\n:1\r\n", nil, true},
+        {"truncated bulk string", "*1\r\n$10\r\nabc", nil, true},
+        {"missing array element", "*2\r\n$3\r\nGET\r\n", nil, true},
+        {"bulk without crlf", "*1\r\n$3\r\nGETxx", nil, true},
+        {"inline too long", strings.Repeat("a", maxLineLen+1) + "\r\n", nil, true},
+        {"inline too many args", strings.Repeat("a ", maxCommandArgs+1) + "\r\n", nil, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := readCommand(bufio.NewReader(strings.NewReader(tt.input)))
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("readCommand() error = %v, wantErr %v", err, tt.wantErr)
+            }
+            if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("readCommand() = %q, want %q", got, tt.want)
+            }
+            if tt.wantErr && errors.Is(err, io.EOF) {
+                t.Errorf("readCommand() = %v, a broken command must not look like a clean hang-up", err)
+            }
+        })
+    }
+}
+
+func TestReadCommandEOF(t *testing.T) {
+    r := bufio.NewReader(strings.NewReader("PING\r\n"))
+    if _, err := readCommand(r); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := readCommand(r); !errors.Is(err, io.EOF) {
+        t.Errorf("readCommand() at end of input = %v, want EOF", err)
+    }
+}
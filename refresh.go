@@ -0,0 +1,134 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "time"
+
+    dht "github.com/libp2p/go-libp2p-kad-dht"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const refreshTimeout = 10 * time.Second
+
+// refreshAround looks up the peers closest to key. Peers that answer the
+// lookup are added to the routing table, so the buckets covering key are
+// fresh before a Put/Get without waiting for the periodic refresh.
+func refreshAround(ctx context.Context, kdht *dht.IpfsDHT, key string) error {
+    ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+    defer cancel()
+
+    before := kdht.RoutingTable().Size()
+    peers, err := kdht.GetClosestPeers(ctx, key)
+    if err != nil {
+        return fmt.Errorf("failed to refresh routing table around %s: %w", key, err)
+    }
+
+    log.Printf("Refreshed routing table around %s: %d closest peers, table size %d -> %d",
+        key, len(peers), before, kdht.RoutingTable().Size())
+    return nil
+}
+
+// refreshKey refreshes the routing table around key if the node was
+// started with -refresh.
+func (n *node) refreshKey(key string) {
+    if !n.refresh {
+        return
+    }
+    if err := refreshAround(context.Background(), n.IpfsDHT, key); err != nil {
+        log.Printf("Refresh error: %v", err)
+    }
+}
+
+const (
+    // Churn, as the share of routing table peers that changed per minute,
+    // above which we refresh more often and below which we back off.
+    highChurn = 0.05
+    lowChurn  = 0.01
+
+    initialRefreshInterval = 10 * time.Minute
+
+    // kad-dht's default RoutingTable.RefreshInterval, which makeNode keeps.
+    // A normal refresh skips buckets refreshed more recently than this.
+    dhtRefreshPeriod = 10 * time.Minute
+)
+
+// refreshLoop refreshes the routing table at an interval that follows peer
+// churn: it halves after a period of high churn and doubles after a quiet
+// one, staying within [minInterval, maxInterval]. Refreshes are skipped
+// while the node is idle. The DHT's own periodic refresh must be disabled
+// for this to have any effect.
+func refreshLoop(ctx context.Context, kdht *dht.IpfsDHT, idle *idleTracker, minInterval, maxInterval time.Duration) {
+    interval := min(max(initialRefreshInterval, minInterval), maxInterval)
+
+    prev, since := peerSet(kdht), time.Now()
+    timer := time.NewTimer(interval)
+    defer timer.Stop()
+
+    for {
+        select {
+        case <-timer.C:
+        case <-ctx.Done():
+            return
+        }
+        if idle.suspended() {
+            timer.Reset(interval)
+            continue
+        }
+
+        cur := peerSet(kdht)
+        rate := churnRate(prev, cur, time.Since(since))
+        prev, since = cur, time.Now()
+
+        switch {
+        case rate > highChurn && interval > minInterval:
+            interval = max(interval/2, minInterval)
+            log.Printf("Routing table churn %.3f/min, refreshing every %s", rate, interval)
+        case rate < lowChurn && interval < maxInterval:
+            interval = min(interval*2, maxInterval)
+            log.Printf("Routing table churn %.3f/min, refreshing every %s", rate, interval)
+        }
+
+        // Below the DHT's own period a normal refresh would skip every
+        // bucket, so force it
+        refresh := kdht.RefreshRoutingTable
+        if interval < dhtRefreshPeriod {
+            refresh = kdht.ForceRefresh
+        }
+        if err := <-refresh(); err != nil {
+            log.Printf("Routing table refresh failed: %v", err)
+        }
+        timer.Reset(interval)
+    }
+}
+
+func peerSet(kdht *dht.IpfsDHT) map[peer.ID]struct{} {
+    set := make(map[peer.ID]struct{})
+    for _, p := range kdht.RoutingTable().ListPeers() {
+        set[p] = struct{}{}
+    }
+    return set
+}
+
+// churnRate is the share of peers that joined or left between two routing
+// table snapshots taken elapsed apart, per minute.
+func churnRate(prev, cur map[peer.ID]struct{}, elapsed time.Duration) float64 {
+    changed := 0
+    for p := range cur {
+        if _, ok := prev[p]; !ok {
+            changed++
+        }
+    }
+    for p := range prev {
+        if _, ok := cur[p]; !ok {
+            changed++
+        }
+    }
+
+    size := max(len(prev), len(cur))
+    if size == 0 || elapsed <= 0 {
+        return 0
+    }
+    return float64(changed) / float64(size) / elapsed.Minutes()
+}
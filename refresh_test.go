@@ -0,0 +1,40 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+func idSet(ids ...peer.ID) map[peer.ID]struct{} {
+    s := make(map[peer.ID]struct{})
+    for _, id := range ids {
+        s[id] = struct{}{}
+    }
+    return s
+}
+
+func TestChurnRate(t *testing.T) {
+    tests := []struct {
+        name      string
+        prev, cur map[peer.ID]struct{}
+        elapsed   time.Duration
+        want      float64
+    }{
+        {"unchanged", idSet("a", "b"), idSet("a", "b"), time.Minute, 0},
+        {"one joined", idSet("a", "b", "c", "d"), idSet("a", "b", "c", "d", "e"), time.Minute, 0.2},
+        {"one left", idSet("a", "b", "c", "d"), idSet("a", "b", "c"), time.Minute, 0.25},
+        {"replaced over two minutes", idSet("a", "b"), idSet("a", "c"), 2 * time.Minute, 0.5},
+        {"all replaced", idSet("a"), idSet("b"), time.Minute, 2},
+        {"both empty", idSet(), idSet(), time.Minute, 0},
+        {"no time passed", idSet("a"), idSet("b"), 0, 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := churnRate(tt.prev, tt.cur, tt.elapsed); got != tt.want {
+                t.Errorf("churnRate() = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
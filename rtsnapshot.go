@@ -0,0 +1,190 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "slices"
+    "sort"
+    "strings"
+    "time"
+
+    dht "github.com/libp2p/go-libp2p-kad-dht"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+    rtSnapshotInterval  = time.Minute
+    rtSnapshotRetention = 7 * 24 * time.Hour
+    rtSnapshotLayout    = "20060102T150405Z"
+)
+
+// rtSnapshot is the routing table's peer list at one point in time.
+type rtSnapshot struct {
+    Time  time.Time `json:"time"`
+    Peers []peer.ID `json:"peers"`
+}
+
+func (s *rtSnapshot) set() map[peer.ID]struct{} {
+    set := make(map[peer.ID]struct{}, len(s.Peers))
+    for _, p := range s.Peers {
+        set[p] = struct{}{}
+    }
+    return set
+}
+
+// snapshotLoop writes a routing table snapshot to dir every
+// rtSnapshotInterval, logs the churn rate since the previous one and
+// removes snapshots older than rtSnapshotRetention.
+func snapshotLoop(ctx context.Context, kdht *dht.IpfsDHT, dir string) {
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        log.Printf("Routing table snapshots disabled: %v", err)
+        return
+    }
+
+    ticker := time.NewTicker(rtSnapshotInterval)
+    defer ticker.Stop()
+
+    var prev *rtSnapshot
+    for {
+        select {
+        case <-ticker.C:
+        case <-ctx.Done():
+            return
+        }
+
+        snap := &rtSnapshot{Time: time.Now().UTC(), Peers: kdht.RoutingTable().ListPeers()}
+        if err := writeSnapshot(dir, snap); err != nil {
+            log.Printf("Failed to write routing table snapshot: %v", err)
+        }
+        if prev != nil {
+            rate := churnRate(prev.set(), snap.set(), snap.Time.Sub(prev.Time))
+            log.Printf("Routing table: %d peers, churn %.3f/min", len(snap.Peers), rate)
+        }
+        prev = snap
+
+        pruneSnapshots(dir, snap.Time.Add(-rtSnapshotRetention))
+    }
+}
+
+func writeSnapshot(dir string, snap *rtSnapshot) error {
+    data, err := json.Marshal(snap)
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(filepath.Join(dir, snap.Time.Format(rtSnapshotLayout)+".json"), data, 0o644)
+}
+
+// snapshotTimes lists the times of all snapshots in dir, oldest first.
+func snapshotTimes(dir string) ([]time.Time, error) {
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return nil, err
+    }
+    var times []time.Time
+    for _, e := range entries {
+        t, err := time.Parse(rtSnapshotLayout, strings.TrimSuffix(e.Name(), ".json"))
+        if err == nil {
+            times = append(times, t)
+        }
+    }
+    sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+    return times, nil
+}
+
+func pruneSnapshots(dir string, before time.Time) {
+    times, err := snapshotTimes(dir)
+    if err != nil {
+        return
+    }
+    for _, t := range times {
+        if t.Before(before) {
+            os.Remove(filepath.Join(dir, t.Format(rtSnapshotLayout)+".json"))
+        }
+    }
+}
+
+// loadSnapshot reads the latest snapshot taken at or before at.
+func loadSnapshot(dir string, at time.Time) (*rtSnapshot, error) {
+    times, err := snapshotTimes(dir)
+    if err != nil {
+        return nil, err
+    }
+    i := sort.Search(len(times), func(i int) bool { return times[i].After(at) })
+    if i == 0 {
+        return nil, fmt.Errorf("no snapshot taken at or before %s", at.Format(time.RFC3339))
+    }
+
+    data, err := os.ReadFile(filepath.Join(dir, times[i-1].Format(rtSnapshotLayout)+".json"))
+    if err != nil {
+        return nil, err
+    }
+    snap := new(rtSnapshot)
+    if err := json.Unmarshal(data, snap); err != nil {
+        return nil, fmt.Errorf("invalid snapshot %s: %w", times[i-1].Format(rtSnapshotLayout), err)
+    }
+    return snap, nil
+}
+
+// parseSnapshotTime accepts an RFC 3339 time or a duration meaning that
+// long ago, e.g. "30m".
+func parseSnapshotTime(s string) (time.Time, error) {
+    if d, err := time.ParseDuration(s); err == nil {
+        return time.Now().Add(-d), nil
+    }
+    t, err := time.Parse(time.RFC3339, s)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a duration", s)
+    }
+    return t, nil
+}
+
+// rtDiff prints the peers that joined and left the routing table between
+// the snapshots closest to from and to, and the churn rate between them.
+func rtDiff(dir, from, to string) error {
+    t1, err := parseSnapshotTime(from)
+    if err != nil {
+        return err
+    }
+    t2, err := parseSnapshotTime(to)
+    if err != nil {
+        return err
+    }
+    a, err := loadSnapshot(dir, t1)
+    if err != nil {
+        return err
+    }
+    b, err := loadSnapshot(dir, t2)
+    if err != nil {
+        return err
+    }
+
+    before, after := a.set(), b.set()
+    var joined, left []string
+    for p := range after {
+        if _, ok := before[p]; !ok {
+            joined = append(joined, p.String())
+        }
+    }
+    for p := range before {
+        if _, ok := after[p]; !ok {
+            left = append(left, p.String())
+        }
+    }
+    slices.Sort(joined)
+    slices.Sort(left)
+
+    fmt.Printf("Routing table %s (%d peers) -> %s (%d peers)\n",
+        a.Time.Format(time.RFC3339), len(a.Peers), b.Time.Format(time.RFC3339), len(b.Peers))
+    for _, p := range joined {
+        fmt.Printf("  + %s\n", p)
+    }
+    for _, p := range left {
+        fmt.Printf("  - %s\n", p)
+    }
+    fmt.Printf("%d joined, %d left, churn %.3f/min\n", len(joined), len(left), churnRate(before, after, b.Time.Sub(a.Time)))
+    return nil
+}
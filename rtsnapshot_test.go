@@ -0,0 +1,92 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestParseSnapshotTime(t *testing.T) {
+    tests := []struct {
+        name    string
+        in      string
+        want    time.Time
+        ago     time.Duration
+        wantErr bool
+    }{
+        {name: "rfc3339", in: "2026-10-16T10:05:00Z", want: time.Date(2026, 10, 16, 10, 5, 0, 0, time.UTC)},
+        {name: "with offset", in: "2026-10-16T12:05:00+02:00", want: time.Date(2026, 10, 16, 10, 5, 0, 0, time.UTC)},
+        {name: "duration ago", in: "30m", ago: 30 * time.Minute},
+        {name: "zero duration", in: "0s"},
+        {name: "garbage", in: "yesterday", wantErr: true},
+        {name: "date only", in: "2026-10-16", wantErr: true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            before := time.Now()
+            got, err := parseSnapshotTime(tt.in)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("parseSnapshotTime() error = %v, wantErr %v", err, tt.wantErr)
+            }
+            if tt.wantErr {
+                return
+            }
+            if !tt.want.IsZero() {
+                if !got.Equal(tt.want) {
+                    t.Errorf("parseSnapshotTime() = %s, want %s", got, tt.want)
+                }
+                return
+            }
+            // Durations count back from now
+            if lo, hi := before.Add(-tt.ago), time.Now().Add(-tt.ago); got.Before(lo) || got.After(hi) {
+                t.Errorf("parseSnapshotTime() = %s, want between %s and %s", got, lo, hi)
+            }
+        })
+    }
+}
+
+func TestLoadSnapshot(t *testing.T) {
+    dir := t.TempDir()
+    base := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+    ids := make([]peer.ID, 3)
+    for i := range ids {
+        key, err := fleetIdentity("snapshots", i)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if ids[i], err = peer.IDFromPrivateKey(key); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for i, id := range ids {
+        snap := &rtSnapshot{Time: base.Add(time.Duration(i) * 10 * time.Minute), Peers: []peer.ID{id}}
+        if err := writeSnapshot(dir, snap); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    tests := []struct {
+        name    string
+        at      time.Time
+        want    int
+        wantErr bool
+    }{
+        {"exact time", base.Add(10 * time.Minute), 1, false},
+        {"between snapshots", base.Add(15 * time.Minute), 1, false},
+        {"after the last", base.Add(time.Hour), 2, false},
+        {"first", base, 0, false},
+        {"before the first", base.Add(-time.Second), 0, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            snap, err := loadSnapshot(dir, tt.at)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("loadSnapshot() error = %v, wantErr %v", err, tt.wantErr)
+            }
+            if !tt.wantErr && (len(snap.Peers) != 1 || snap.Peers[0] != ids[tt.want]) {
+                t.Errorf("loadSnapshot() peers = %v, want [%s]", snap.Peers, ids[tt.want])
+            }
+        })
+    }
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+    sessionReadTimeout = 5 * time.Second
+
+    // Writes are remembered until the DHT has long converged on them, and
+    // only so many of them, so that a long-lived Redis client that writes
+    // many keys doesn't hold them all in memory
+    sessionWriteTTL  = 10 * time.Minute
+    sessionMaxWrites = 1024
+)
+
+// session guarantees read-your-writes. Reads of a key written through the
+// session go to the replicas that acknowledged the write, and fall back to
+// the written value when none of them answers, so the session never reads
+// something older than its own write while the DHT is still converging.
+type session struct {
+    token string
+    n     *node
+
+    mu     sync.Mutex
+    writes map[string]sessionWrite
+}
+
+type sessionWrite struct {
+    // value as stored, with its sequence number
+    raw      []byte
+    replicas []peer.ID
+    written  time.Time
+}
+
+func (w sessionWrite) value() []byte {
+    return w.raw[seqSize:]
+}
+
+func newSession(n *node) *session {
+    buf := make([]byte, 8)
+    rand.Read(buf)
+    return &session{
+        token:  hex.EncodeToString(buf),
+        n:      n,
+        writes: make(map[string]sessionWrite),
+    }
+}
+
+// put records the write only if it succeeded, so that a failed write is
+// never read back from the session.
+func (s *session) put(key string, value []byte, minReplicas int) (*putReport, error) {
+    report, err := put(s.n, key, value, minReplicas)
+    if err != nil {
+        return report, err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.remember(key, sessionWrite{raw: encodeValue(report.seq, value), replicas: report.acked, written: time.Now()})
+    return report, nil
+}
+
+// remember records a write, first forgetting expired writes and, if the
+// session is still full, the oldest one. Callers hold s.mu.
+func (s *session) remember(key string, w sessionWrite) {
+    delete(s.writes, key)
+    var oldest string
+    for k, old := range s.writes {
+        if w.written.Sub(old.written) > sessionWriteTTL {
+            delete(s.writes, k)
+        } else if oldest == "" || old.written.Before(s.writes[oldest].written) {
+            oldest = k
+        }
+    }
+    if len(s.writes) >= sessionMaxWrites {
+        delete(s.writes, oldest)
+    }
+    s.writes[key] = w
+}
+
+func (s *session) get(key string) []byte {
+    s.mu.Lock()
+    w, ok := s.writes[key]
+    s.mu.Unlock()
+    if !ok || time.Since(w.written) > sessionWriteTTL {
+        return get(s.n, key)
+    }
+
+    return read(s.n, key, func(ctx context.Context, k string) ([]byte, error) {
+        ctx, cancel := context.WithTimeout(ctx, sessionReadTimeout)
+        defer cancel()
+
+        // Ask the first replica that acked our write and answers. Its value
+        // only replaces ours if the validator prefers it.
+        for _, p := range w.replicas {
+            rec, _, err := s.n.messenger.GetValue(ctx, p, k)
+            if err != nil || rec == nil {
+                continue
+            }
+            if i, err := s.n.Validator.Select(k, [][]byte{w.raw, rec.GetValue()}); err == nil && i == 1 {
+                _, val, _ := decodeValue(rec.GetValue())
+                fmt.Printf("Found newer value for key=%s on %s: %s\n", key, p, string(val))
+                return val, nil
+            }
+            if bytes.Equal(rec.GetValue(), w.raw) {
+                fmt.Printf("Found value for key=%s on %s: %s\n", key, p, string(w.value()))
+                return w.value(), nil
+            }
+            break
+        }
+
+        fmt.Printf("Found value for key=%s in session %s: %s\n", key, s.token, string(w.value()))
+        return w.value(), nil
+    })
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// testNodes starts two DHT servers on loopback that know each other, like
+// the demo does.
+func testNodes(t *testing.T) (a, b *node) {
+    t.Helper()
+    cfg := nodeConfig{
+        serverMode:  true,
+        listenAddrs: []string{"/ip4/127.0.0.1/tcp/0"},
+        refreshMin:  time.Minute,
+        refreshMax:  time.Hour,
+    }
+    start := func(cfg nodeConfig) *node {
+        n, err := makeNode(cfg)
+        if err != nil {
+            t.Fatal(err)
+        }
+        t.Cleanup(n.stop)
+        return n
+    }
+
+    a = start(cfg)
+    cfg.bootstrapPeers = []peer.AddrInfo{{ID: a.PeerID(), Addrs: a.Host().Addrs()}}
+    b = start(cfg)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    for _, n := range []*node{a, b} {
+        if err := waitForPeers(ctx, n.IpfsDHT, 1); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return a, b
+}
+
+func TestSessionGet(t *testing.T) {
+    a, b := testNodes(t)
+    s := newSession(a)
+
+    t.Run("own write", func(t *testing.T) {
+        if _, err := s.put("own", []byte("mine"), 1); err != nil {
+            t.Fatal(err)
+        }
+        if got := string(s.get("own")); got != "mine" {
+            t.Errorf("get() = %q, want mine", got)
+        }
+    })
+
+    t.Run("newer write elsewhere", func(t *testing.T) {
+        if _, err := s.put("newer", []byte("mine"), 1); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := put(b, "newer", []byte("theirs"), 0); err != nil {
+            t.Fatal(err)
+        }
+        if got := string(s.get("newer")); got != "theirs" {
+            t.Errorf("get() = %q, want theirs", got)
+        }
+    })
+
+    // The replica acked our write but still answers with an older value,
+    // e.g. one that arrived late through hinted handoff
+    t.Run("older value on the replica", func(t *testing.T) {
+        if _, err := put(b, "older", []byte("theirs"), 0); err != nil {
+            t.Fatal(err)
+        }
+        s.mu.Lock()
+        s.remember("older", sessionWrite{
+            raw:      encodeValue(nextSeq(), []byte("mine")),
+            replicas: []peer.ID{b.PeerID()},
+            written:  time.Now(),
+        })
+        s.mu.Unlock()
+        if got := string(s.get("older")); got != "mine" {
+            t.Errorf("get() = %q, want mine", got)
+        }
+    })
+
+    t.Run("replica unreachable", func(t *testing.T) {
+        s.mu.Lock()
+        s.remember("unreachable", sessionWrite{
+            raw:      encodeValue(nextSeq(), []byte("mine")),
+            replicas: []peer.ID{fleetPeerID(t, "session", 0)},
+            written:  time.Now(),
+        })
+        s.mu.Unlock()
+        if got := string(s.get("unreachable")); got != "mine" {
+            t.Errorf("get() = %q, want mine", got)
+        }
+    })
+}
+
+func TestSessionRemember(t *testing.T) {
+    s := newSession(nil)
+    now := time.Now()
+    s.remember("expired", sessionWrite{raw: encodeValue(1, []byte("x")), written: now.Add(-sessionWriteTTL - time.Second)})
+    for i := 0; i < sessionMaxWrites; i++ {
+        s.remember(string(rune('a'+i%26))+string(rune('a'+i/26)), sessionWrite{raw: encodeValue(1, []byte("x")), written: now.Add(time.Duration(i))})
+    }
+    if _, ok := s.writes["expired"]; ok {
+        t.Error("expired write kept")
+    }
+    if len(s.writes) != sessionMaxWrites {
+        t.Fatalf("session holds %d writes, want %d", len(s.writes), sessionMaxWrites)
+    }
+
+    s.remember("new", sessionWrite{raw: encodeValue(1, []byte("x")), written: now.Add(time.Second)})
+    if len(s.writes) != sessionMaxWrites {
+        t.Errorf("session holds %d writes after a full insert, want %d", len(s.writes), sessionMaxWrites)
+    }
+    if _, ok := s.writes["aa"]; ok {
+        t.Error("oldest write kept when the session was full")
+    }
+}
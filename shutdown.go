@@ -0,0 +1,117 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "os"
+    "sync/atomic"
+    "time"
+)
+
+// nodeStats counts what the node did over its lifetime.
+type nodeStats struct {
+    start time.Time
+
+    puts, putErrors            atomic.Int64
+    gets, getErrors, getMisses atomic.Int64
+    served                     atomic.Int64
+    inflight                   atomic.Int64
+}
+
+func newNodeStats() *nodeStats {
+    return &nodeStats{start: time.Now()}
+}
+
+// begin counts an operation as in flight until the returned func is called.
+func (s *nodeStats) begin() func() {
+    s.inflight.Add(1)
+    return func() { s.inflight.Add(-1) }
+}
+
+// shutdownReport summarizes a node's run when it stops.
+type shutdownReport struct {
+    Started      time.Time `json:"started"`
+    Stopped      time.Time `json:"stopped"`
+    Uptime       string    `json:"uptime"`
+    Puts         int64     `json:"puts"`
+    PutErrors    int64     `json:"put_errors"`
+    Gets         int64     `json:"gets"`
+    GetErrors    int64     `json:"get_errors"`
+    GetMisses    int64     `json:"get_misses"`
+    Served       int64     `json:"requests_served"`
+    Unfinished   int64     `json:"unfinished_ops"`
+    PendingHints int       `json:"pending_hints"`
+    Peers        int       `json:"connected_peers"`
+    RoutingTable int       `json:"routing_table_size"`
+    // Why the node stopped early, empty if it finished normally
+    Error string `json:"error,omitempty"`
+}
+
+func (n *node) report() *shutdownReport {
+    now := time.Now()
+    return &shutdownReport{
+        Started:      n.stats.start,
+        Stopped:      now,
+        Uptime:       now.Sub(n.stats.start).Round(time.Second).String(),
+        Puts:         n.stats.puts.Load(),
+        PutErrors:    n.stats.putErrors.Load(),
+        Gets:         n.stats.gets.Load(),
+        GetErrors:    n.stats.getErrors.Load(),
+        GetMisses:    n.stats.getMisses.Load(),
+        Served:       n.stats.served.Load(),
+        Unfinished:   n.stats.inflight.Load(),
+        PendingHints: n.hints.count(),
+        Peers:        len(n.Host().Network().Peers()),
+        RoutingTable: n.RoutingTable().Size(),
+    }
+}
+
+// shutdown logs a report of the node's run, including the error it failed
+// with if any, writes it to path if set, and stops the node.
+func (n *node) shutdown(path string, failure error) {
+    report := n.report()
+    if failure != nil {
+        report.Error = failure.Error()
+    }
+    data, err := json.Marshal(report)
+    if err != nil {
+        log.Printf("Failed to encode shutdown report: %v", err)
+    } else {
+        log.Printf("Shutdown report: %s", data)
+        if path != "" {
+            if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+                log.Printf("Failed to write shutdown report: %v", err)
+            }
+        }
+    }
+
+    n.stop()
+}
+
+// stop ends the node's background loops and closes the DHT and the host.
+func (n *node) stop() {
+    n.cancel()
+    if err := n.Close(); err != nil {
+        log.Printf("Failed to stop DHT: %v", err)
+    }
+    if err := n.Host().Close(); err != nil {
+        log.Printf("Failed to close host: %v", err)
+    }
+}
+
+// untilSignal runs fn and returns its error, or fails as soon as ctx is
+// cancelled by a signal, leaving fn to be cut short by the exit.
+func untilSignal(ctx context.Context, fn func() error) error {
+    done := make(chan error, 1)
+    go func() {
+        done <- fn()
+    }()
+    select {
+    case err := <-done:
+        return err
+    case <-ctx.Done():
+        return errors.New("interrupted by signal")
+    }
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "time"
+)
+
+// Upper bound for values stored under /myapp/
+const maxValueSize = 64 << 10
+
+// Every stored value starts with a big-endian sequence number, the
+// writer's clock in nanoseconds when it wrote the value, so that peers
+// can tell which of two values for a key is newer.
+const seqSize = 8
+
+// encodeValue prefixes value with its sequence number.
+func encodeValue(seq uint64, value []byte) []byte {
+    raw := make([]byte, seqSize+len(value))
+    binary.BigEndian.PutUint64(raw, seq)
+    copy(raw[seqSize:], value)
+    return raw
+}
+
+// decodeValue splits a stored value into its sequence number and value.
+func decodeValue(raw []byte) (uint64, []byte, error) {
+    if len(raw) < seqSize {
+        return 0, nil, errors.New("value has no sequence number")
+    }
+    return binary.BigEndian.Uint64(raw), raw[seqSize:], nil
+}
+
+// nextSeq returns the sequence number for a value written now.
+func nextSeq() uint64 {
+    return uint64(time.Now().UnixNano())
+}
+
+// appValidator accepts any non-empty value under the /myapp/ namespace
+// and prefers the value with the highest sequence number. Without it the
+// DHT rejects our keys with "invalid record keytype".
+type appValidator struct{}
+
+func (appValidator) Validate(key string, raw []byte) error {
+    _, value, err := decodeValue(raw)
+    if err != nil {
+        return err
+    }
+    if len(value) == 0 {
+        return errors.New("empty value")
+    }
+    if len(value) > maxValueSize {
+        return fmt.Errorf("value is %d bytes, limit is %d", len(value), maxValueSize)
+    }
+    return nil
+}
+
+// Select picks the valid value with the highest sequence number. Equal
+// sequence numbers are broken by comparing the values, so that every peer
+// settles on the same one.
+func (v appValidator) Select(key string, values [][]byte) (int, error) {
+    best := -1
+    for i, raw := range values {
+        if v.Validate(key, raw) != nil {
+            continue
+        }
+        if best < 0 || newer(raw, values[best]) {
+            best = i
+        }
+    }
+    if best < 0 {
+        return 0, errors.New("no valid value to select from")
+    }
+    return best, nil
+}
+
+func newer(a, b []byte) bool {
+    sa, sb := binary.BigEndian.Uint64(a), binary.BigEndian.Uint64(b)
+    if sa != sb {
+        return sa > sb
+    }
+    return bytes.Compare(a[seqSize:], b[seqSize:]) > 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "bytes"
+    "testing"
+)
+
+func TestAppValidatorValidate(t *testing.T) {
+    tests := []struct {
+        name    string
+        raw     []byte
+        wantErr bool
+    }{
+        {"value", encodeValue(1, []byte("bar")), false},
+        {"no sequence number", []byte("bar"), true},
+        {"empty value", encodeValue(1, nil), true},
+        {"largest value", encodeValue(1, make([]byte, maxValueSize)), false},
+        {"too large", encodeValue(1, make([]byte, maxValueSize+1)), true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := appValidator{}.Validate("/myapp/foo", tt.raw)
+            if (err != nil) != tt.wantErr {
+                t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+func TestAppValidatorSelect(t *testing.T) {
+    older := encodeValue(1, []byte("old"))
+    newer := encodeValue(2, []byte("new"))
+    tieLow := encodeValue(2, []byte("a"))
+    tieHigh := encodeValue(2, []byte("b"))
+    invalid := []byte("x")
+
+    tests := []struct {
+        name    string
+        values  [][]byte
+        want    int
+        wantErr bool
+    }{
+        {"single", [][]byte{older}, 0, false},
+        {"newer first", [][]byte{newer, older}, 0, false},
+        {"newer last", [][]byte{older, newer}, 1, false},
+        {"tie broken by value", [][]byte{tieHigh, tieLow}, 0, false},
+        {"tie in any order", [][]byte{tieLow, tieHigh}, 1, false},
+        {"invalid skipped", [][]byte{invalid, older}, 1, false},
+        {"none valid", [][]byte{invalid}, 0, true},
+        {"empty", nil, 0, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := appValidator{}.Select("/myapp/foo", tt.values)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("Select() error = %v, wantErr %v", err, tt.wantErr)
+            }
+            if !tt.wantErr && got != tt.want {
+                t.Errorf("Select() = %d, want %d", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestDecodeValue(t *testing.T) {
+    seq, value, err := decodeValue(encodeValue(42, []byte("bar")))
+    if err != nil || seq != 42 || !bytes.Equal(value, []byte("bar")) {
+        t.Errorf("decodeValue() = %d, %q, %v", seq, value, err)
+    }
+}